@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	natstransport "github.com/miracl/mrpc/transport/nats"
+	"github.com/miracl/mrpcproxy/sdk"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	defaultAddr         = ":8080"
+	defaultTransport    = "mem://"
+	defaultDrainTimeout = 30000
+)
+
+var (
+	errNoEndpoints   = errors.New("no endpoints in the config or the endpoints file")
+	errNATSConnected = errors.New("nats not connected")
+)
+
+// newTransport creates the MRPC service for the transport URL and the transport check of
+// the health endpoints, nil if the transport can't fail.
+type newTransport func(u *url.URL, t sdk.TransportConfig) (service *mrpc.Service, check func() error, err error)
+
+// transports create the MRPC service for the transport URL scheme. Other transports are
+// added here with their constructor.
+var transports = map[string]newTransport{
+	"mem": func(_ *url.URL, t sdk.TransportConfig) (*mrpc.Service, func() error, error) {
+		service, err := memService(t)
+		return service, nil, err
+	},
+	// The NATS connection is retried in the background, the proxy starts before the
+	// server is reachable and the requests time out until it is.
+	"nats": func(u *url.URL, t sdk.TransportConfig) (*mrpc.Service, func() error, error) {
+		conn, err := nats.Connect(u.String(), nats.Name(t.Name), nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1))
+		if err != nil {
+			return nil, nil, fmt.Errorf("nats connect %v: %v", u.Host, err)
+		}
+
+		service, err := mrpc.NewService(natstransport.New(conn), mrpc.WithNGV(t.Name, t.Group, t.Version))
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		check := func() error {
+			if !conn.IsConnected() {
+				return fmt.Errorf("%w: %v", errNATSConnected, conn.Status())
+			}
+			return nil
+		}
+		return service, check, nil
+	},
+}
+
+func memService(t sdk.TransportConfig) (*mrpc.Service, error) {
+	return mrpc.NewService(mem.New(), mrpc.WithNGV(t.Name, t.Group, t.Version))
+}
+
+// loadConfig reads the configuration file, the defaults are used without a file.
+func loadConfig(path string) (*sdk.Config, error) {
+	cfg := &sdk.Config{}
+	if path != "" {
+		var err error
+		if cfg, err = sdk.LoadConfig(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Server.Addr == "" && len(cfg.Server.Listeners) == 0 {
+		cfg.Server.Addr = defaultAddr
+	}
+	if cfg.Server.DrainTimeout == 0 {
+		cfg.Server.DrainTimeout = defaultDrainTimeout
+	}
+	if cfg.Transport.URL == "" {
+		cfg.Transport.URL = defaultTransport
+	}
+	if cfg.Transport.Name == "" {
+		cfg.Transport.Name = "mrpcproxy"
+	}
+	if _, _, err := transport(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func transport(cfg *sdk.Config) (*url.URL, newTransport, error) {
+	u, err := url.Parse(cfg.Transport.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid transport %v: %v", cfg.Transport.URL, err)
+	}
+	newService, ok := transports[u.Scheme]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown transport: %v", u.Scheme)
+	}
+	return u, newService, nil
+}
+
+func transportService(cfg *sdk.Config) (*mrpc.Service, func() error, error) {
+	u, newService, err := transport(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newService(u, cfg.Transport)
+}
+
+// mockService returns the mock service and the mocked endpoints.
+func mockService(eps []sdk.Endpoint, fixtures string) (*mrpc.Service, []sdk.Endpoint, error) {
+	fx, err := sdk.LoadFixtures(fixtures)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sdk.NewMockService(eps, fx)
+}
+
+func loadEndpoints(path string) ([]sdk.Endpoint, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	return sdk.LoadMapping(path)
+}
+
+// newProxy creates the proxy with the endpoints of the configuration and the endpoints
+// file. With the fixtures file the endpoints are served by the mock service instead of
+// the transport. The transport is connected only when the proxy serves, the offline
+// commands use the mem transport.
+func newProxy(cfg *sdk.Config, eps []sdk.Endpoint, fixtures string, serve bool) (pxy *sdk.Proxy, err error) {
+	eps = append(append([]sdk.Endpoint{}, cfg.Eps()...), eps...)
+	if len(eps) == 0 {
+		return nil, errNoEndpoints
+	}
+
+	var service *mrpc.Service
+	var check func() error
+	switch {
+	case fixtures != "":
+		service, eps, err = mockService(eps, fixtures)
+	case serve:
+		service, check, err = transportService(cfg)
+	default:
+		service, err = memService(cfg.Transport)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pxy, err = sdk.New(cfg.Server.Addr, service, cfg.Options()...)
+	if err != nil {
+		return nil, err
+	}
+	if pxy.Health != nil && check != nil {
+		pxy.Health.Transport = check
+	}
+	if err := pxy.Handle(eps...); err != nil {
+		return nil, err
+	}
+
+	return pxy, nil
+}
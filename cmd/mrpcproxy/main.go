@@ -0,0 +1,223 @@
+// Command mrpcproxy serves the endpoints mapping as HTTP to MRPC proxy.
+//
+// Usage:
+//
+//	mrpcproxy validate [-config proxy.json] [-endpoints endpoints.json]
+//	mrpcproxy routes [-config proxy.json] [-endpoints endpoints.json]
+//	mrpcproxy serve [-config proxy.json] [-endpoints endpoints.json] [-fixtures fixtures.json]
+//	mrpcproxy openapi [-config proxy.json] [-endpoints endpoints.json]
+//	mrpcproxy import -openapi openapi.json
+//
+// The config file is loaded with sdk.LoadConfig, the endpoints file adds endpoints to
+// the ones in the config file. With the fixtures file the endpoints are served by
+// sdk.NewMockService.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/miracl/mrpcproxy/sdk"
+)
+
+const usage = `Usage: mrpcproxy <command> [flags]
+
+Commands:
+  validate  check the endpoints and the config files
+  routes    print the route table
+  serve     start the proxy
+  openapi   print the OpenAPI document of the endpoints
+  import    print the endpoints mapping of an OpenAPI document
+
+Run mrpcproxy <command> -h for the command flags.
+`
+
+var (
+	errUsage     = errors.New("invalid command")
+	errNoOpenAPI = errors.New("no OpenAPI document, set -openapi")
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if err != errUsage && err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(2)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return errUsage
+	}
+
+	cmd := args[0]
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "", "Proxy configuration file")
+	endpointsFile := flags.String("endpoints", "", "Endpoints mapping file")
+	fixturesFile := flags.String("fixtures", "", "Serve the endpoints from the fixtures file without the services")
+
+	switch cmd {
+	case "validate", "routes", "serve", "openapi":
+	case "import":
+		openAPIFile := flags.String("openapi", "", "OpenAPI document to import")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		return importOpenAPI(stdout, *openAPIFile)
+	default:
+		fmt.Fprint(stderr, usage)
+		return errUsage
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	eps, err := loadEndpoints(*endpointsFile)
+	if err != nil {
+		return err
+	}
+	pxy, err := newProxy(cfg, eps, *fixturesFile, cmd == "serve")
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "validate":
+		fmt.Fprintf(stdout, "%v endpoints OK\n", len(pxy.Eps))
+		return nil
+	case "routes":
+		return printRoutes(stdout, pxy)
+	case "openapi":
+		return printOpenAPI(stdout, cfg, pxy)
+	default:
+		return serve(cfg, pxy)
+	}
+}
+
+// printRoutes prints the endpoints sorted by path and method.
+func printRoutes(w io.Writer, pxy *sdk.Proxy) error {
+	eps := append([]sdk.Endpoint{}, pxy.Eps...)
+	sort.Slice(eps, func(i, j int) bool {
+		if eps[i].Path != eps[j].Path {
+			return eps[i].Path < eps[j].Path
+		}
+		return eps[i].Method < eps[j].Method
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tPATH\tTOPIC\tTIMEOUT\tLISTENERS")
+	for _, ep := range eps {
+		topic := ep.Topic
+		if len(ep.Aggregate) > 0 {
+			topics := []string{}
+			for _, b := range ep.Aggregate {
+				topics = append(topics, b.Topic)
+			}
+			topic = strings.Join(topics, ",")
+		}
+		timeout := pxy.Timeout
+		if ep.KeepAlive > 0 {
+			timeout = time.Duration(ep.KeepAlive) * time.Millisecond
+		}
+		listeners := strings.Join(ep.Listeners, ",")
+		if listeners == "" {
+			listeners = "*"
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n", ep.Method, ep.Path, topic, timeout, listeners)
+	}
+
+	return tw.Flush()
+}
+
+// printOpenAPI prints the OpenAPI document with the info of the config file.
+func printOpenAPI(w io.Writer, cfg *sdk.Config, pxy *sdk.Proxy) error {
+	info := sdk.OpenAPIInfo{Title: "mrpcproxy", Version: "1.0.0"}
+	if cfg.Server.OpenAPI != nil {
+		info = *cfg.Server.OpenAPI
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(pxy.OpenAPIDocument(info))
+}
+
+// importOpenAPI prints the endpoints mapping of the OpenAPI document.
+func importOpenAPI(w io.Writer, path string) error {
+	if path == "" {
+		return errNoOpenAPI
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	doc, err := sdk.ParseOpenAPI(data)
+	if err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	eps, err := sdk.EndpointsFromOpenAPI(doc)
+	if err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	mapping, err := sdk.MarshalMapping(eps)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", mapping)
+	return err
+}
+
+func serve(cfg *sdk.Config, pxy *sdk.Proxy) error {
+	if addr := cfg.Server.MetricsAddr; addr != "" {
+		expvar.Publish("inFlight", expvar.Func(func() interface{} { return pxy.InFlight() }))
+		expvar.Publish("ready", expvar.Func(func() interface{} { return pxy.Ready() }))
+
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", expvar.Handler())
+		go func() {
+			log.Printf("metrics server stopped: %v", http.ListenAndServe(addr, mux))
+		}()
+	}
+
+	if pxy.Admin != nil {
+		go func() {
+			log.Printf("admin server stopped: %v", pxy.ServeAdmin())
+		}()
+	}
+
+	serveFunc := pxy.Serve
+	if cfg.Server.CertFile != "" {
+		serveFunc = func() error {
+			return pxy.ServeTLS(cfg.Server.CertFile, cfg.Server.KeyFile)
+		}
+	}
+
+	log.Printf("serving %v endpoints on %v", len(pxy.Eps), cfg.Server.Addr)
+	err := pxy.ServeUntilSignal(serveFunc, time.Duration(cfg.Server.DrainTimeout)*time.Millisecond)
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	log.Println("stopped")
+	return nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpcproxy/sdk"
+)
+
+const testEndpoints = `{
+	"/users/:id": {"endpoints": [
+		{"method": "GET", "topic": "users.get.{{.id}}", "keepAlive": 2000},
+		{"method": "DELETE", "topic": "users.delete", "listeners": ["internal"]}
+	]},
+	"/dashboard": {"endpoints": [
+		{"method": "GET", "aggregate": [{"name": "a", "topic": "a.get"}, {"name": "b", "topic": "b.get"}]}
+	]}
+}`
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "mrpcproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRun(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"endpoints.json": testEndpoints,
+		"conflict.json":  `{"/a/:id": {"endpoints": [{"method": "GET", "topic": "a"}]}, "/a/:name": {"endpoints": [{"method": "GET", "topic": "a"}]}}`,
+		"template.json":  `{"/a": {"endpoints": [{"method": "GET", "topic": "a.{{.id"}]}}`,
+		"proxy.json":     `{"server": {"addr": ":9000"}, "defaults": {"timeout": 500}, "transport": {"url": "mem://"}}`,
+		"full.json":      `{"endpoints": {"/b": {"endpoints": [{"method": "GET", "topic": "b"}]}}}`,
+		"nats.json":      `{"transport": {"url": "nats://localhost:4222"}}`,
+		"amqp.json":      `{"transport": {"url": "amqp://localhost:5672"}}`,
+		"broken.json":    `{"server": {"addr": 1}}`,
+		"fixtures.json":  `{"/users/:id": {"GET": {"body": "user {{.Params.id}}"}}}`,
+		"unknown.json":   `{"/posts": {"GET": {"status": 204}}}`,
+	})
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		args   []string
+		stdout []string
+		err    string
+	}{
+		{args: []string{}, err: "invalid command"},
+		{args: []string{"start"}, err: "invalid command"},
+		{
+			args:   []string{"validate", "-endpoints", filepath.Join(dir, "endpoints.json")},
+			stdout: []string{"3 endpoints OK"},
+		},
+		{
+			args: []string{"routes", "-endpoints", filepath.Join(dir, "endpoints.json"), "-config", filepath.Join(dir, "proxy.json")},
+			stdout: []string{
+				"METHOD  PATH        TOPIC              TIMEOUT  LISTENERS",
+				"GET     /dashboard  a.get,b.get        500ms    *",
+				"DELETE  /users/:id  users.delete       500ms    internal",
+				"GET     /users/:id  users.get.{{.id}}  2s       *",
+			},
+		},
+		{
+			args: []string{"routes", "-endpoints", filepath.Join(dir, "endpoints.json"), "-fixtures", filepath.Join(dir, "fixtures.json")},
+			stdout: []string{
+				"METHOD  PATH        TOPIC               TIMEOUT  LISTENERS",
+				"GET     /dashboard  a.get,b.get         1s       *",
+				"DELETE  /users/:id  users.delete        1s       internal",
+				"GET     /users/:id  mock.get/users/:id  2s       *",
+			},
+		},
+		{
+			args: []string{"validate", "-endpoints", filepath.Join(dir, "endpoints.json"), "-fixtures", filepath.Join(dir, "unknown.json")},
+			err:  "fixture GET /posts: no endpoint",
+		},
+		{
+			args:   []string{"validate", "-config", filepath.Join(dir, "full.json"), "-endpoints", filepath.Join(dir, "endpoints.json")},
+			stdout: []string{"4 endpoints OK"},
+		},
+		{args: []string{"validate"}, err: "no endpoints"},
+		{args: []string{"import"}, err: "no OpenAPI document"},
+		{args: []string{"validate", "-endpoints", filepath.Join(dir, "missing.json")}, err: "no such file"},
+		{args: []string{"validate", "-endpoints", filepath.Join(dir, "conflict.json")}, err: "invalid route GET /a/:"},
+		{args: []string{"validate", "-endpoints", filepath.Join(dir, "template.json")}, err: "unclosed action"},
+		{
+			args:   []string{"validate", "-endpoints", filepath.Join(dir, "endpoints.json"), "-config", filepath.Join(dir, "nats.json")},
+			stdout: []string{"3 endpoints OK"},
+		},
+		{
+			args: []string{"validate", "-endpoints", filepath.Join(dir, "endpoints.json"), "-config", filepath.Join(dir, "amqp.json")},
+			err:  "unknown transport: amqp",
+		},
+		{
+			args: []string{"validate", "-endpoints", filepath.Join(dir, "endpoints.json"), "-config", filepath.Join(dir, "broken.json")},
+			err:  "broken.json:1:22: json: cannot unmarshal number",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			err := run(tc.args, stdout, stderr)
+
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Unexpected error: got %v want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+			if len(lines) != len(tc.stdout) {
+				t.Fatalf("Unexpected output:\n%v", stdout.String())
+			}
+			for i, line := range lines {
+				if strings.TrimSpace(line) != tc.stdout[i] {
+					t.Errorf("Unexpected line %v: got %q want %q", i, line, tc.stdout[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNewProxyTransport(t *testing.T) {
+	connects := 0
+	errDown := errors.New("down")
+	transports["test"] = func(_ *url.URL, t sdk.TransportConfig) (*mrpc.Service, func() error, error) {
+		connects++
+		service, err := memService(t)
+		return service, func() error { return errDown }, err
+	}
+	defer delete(transports, "test")
+
+	dir := writeFiles(t, map[string]string{
+		"proxy.json": `{"server": {"health": {}}, "transport": {"url": "test://broker"}}`,
+	})
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		serve    bool
+		connects int
+		err      error
+	}{
+		{serve: false, connects: 0},
+		{serve: true, connects: 1, err: errDown},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			connects = 0
+			cfg, err := loadConfig(filepath.Join(dir, "proxy.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pxy, err := newProxy(cfg, []sdk.Endpoint{{Path: "/a", Method: "GET", Topic: "service.a"}}, "", tc.serve)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if connects != tc.connects {
+				t.Errorf("Unexpected transport connects: got %v want %v", connects, tc.connects)
+			}
+			if len(pxy.Eps) != 1 {
+				t.Errorf("Unexpected endpoints: %v", pxy.Eps)
+			}
+			if tc.err == nil && pxy.Health.Transport != nil {
+				t.Errorf("Unexpected transport check")
+			}
+			if tc.err != nil && (pxy.Health.Transport == nil || pxy.Health.Transport() != tc.err) {
+				t.Errorf("Transport check not set")
+			}
+		})
+	}
+}
+
+func TestRunOpenAPI(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"endpoints.json": testEndpoints})
+	defer os.RemoveAll(dir)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := run([]string{"openapi", "-endpoints", filepath.Join(dir, "endpoints.json")}, stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := &sdk.OpenAPIDocument{}
+	if err := json.Unmarshal(stdout.Bytes(), doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Info.Title != "mrpcproxy" {
+		t.Errorf("Unexpected info: %+v", doc.Info)
+	}
+	op := doc.Paths["/users/{id}"]["get"]
+	if op == nil || op.Topic != "users.get.{{.id}}" || op.Timeout != 2000 {
+		t.Errorf("Unexpected operation: %+v", op)
+	}
+	if len(doc.Paths["/dashboard"]["get"].Aggregate) != 2 {
+		t.Errorf("Unexpected paths: %v", doc.Paths)
+	}
+}
+
+func TestRunImport(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"openapi.json": `{"openapi": "3.0.0", "paths": {
+			"/users/{id}": {"get": {"operationId": "usersGet", "responses": {}}},
+			"/users": {"post": {"x-mrpc-topic": "users.create", "x-mrpc-async": true, "responses": {}}}
+		}}`,
+		"swagger.json": `{"swagger": "2.0"}`,
+	})
+	defer os.RemoveAll(dir)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := run([]string{"import", "-openapi", filepath.Join(dir, "openapi.json")}, stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+	eps, err := sdk.ParseMapping(stdout.Bytes())
+	if err != nil {
+		t.Fatalf("Invalid mapping %v:\n%v", err, stdout.String())
+	}
+	if len(eps) != 2 {
+		t.Errorf("Unexpected mapping:\n%v", stdout.String())
+	}
+
+	err = run([]string{"import", "-openapi", filepath.Join(dir, "swagger.json")}, stdout, stderr)
+	if err == nil || !strings.Contains(err.Error(), "swagger.json: unsupported OpenAPI version") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
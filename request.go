@@ -16,4 +16,26 @@ type Request struct {
 	Params    url.Values
 	Msg       []byte
 	Headers   http.Header
+
+	Preconditions *Preconditions     `json:",omitempty"`
+	ClientCert    *ClientCertificate `json:",omitempty"`
+}
+
+// Preconditions are the conditional request headers of a write request. The service
+// should respond with 412 Precondition Failed if they are not met.
+type Preconditions struct {
+	IfMatch           []string `json:",omitempty"` // Entity tags including the quotes
+	IfNoneMatch       []string `json:",omitempty"`
+	IfUnmodifiedSince int64    `json:",omitempty"` // Unix timestamp
+}
+
+// ClientCertificate is the verified TLS client certificate of the request.
+type ClientCertificate struct {
+	Subject        string
+	Issuer         string
+	SerialNumber   string
+	DNSNames       []string `json:",omitempty"`
+	EmailAddresses []string `json:",omitempty"`
+	IPAddresses    []string `json:",omitempty"`
+	URIs           []string `json:",omitempty"`
 }
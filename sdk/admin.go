@@ -0,0 +1,321 @@
+package sdk
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const defaultErrorSamples = 100
+
+var (
+	// ErrNoAdmin is returned by ServeAdmin when the admin API isn't configured
+	ErrNoAdmin = errors.New("admin API not configured")
+	// ErrAdminAuth is returned by ServeAdmin when the admin API has no authentication
+	ErrAdminAuth = errors.New("admin API requires Token or Auth")
+)
+
+// Admin configures the admin API served by ServeAdmin on its own listener. The admin
+// routes are never served on the proxy listeners.
+//
+//	GET /routes           the endpoints with their topic templates and timeouts
+//	GET /inflight         the requests being processed with their age and topic
+//	GET /state            readiness, in-flight counts and the State providers
+//	GET /errors           the recent 5xx responses
+//	GET /debug            the paths with debug logging
+//	POST /debug?path=     enables debug logging of the requests to the path
+//	DELETE /debug?path=   disables debug logging of the path
+//
+// The requests are authenticated with Auth, or with the Token bearer token when Auth is
+// nil.
+type Admin struct {
+	Listener     Listener                   `json:"listener"`
+	Token        string                     `json:"token,omitempty"`
+	Auth         func(r *http.Request) bool `json:"-"`
+	ErrorSamples int                        `json:"errorSamples,omitempty"` // Recent errors kept, defaults to 100
+
+	// Additional state reported by /state, e.g. circuit breakers or rate limiters
+	State map[string]func() interface{} `json:"-"`
+
+	mu         sync.Mutex
+	debugPaths map[string]bool
+	errors     []ErrorSample
+	server     *http.Server
+}
+
+// RouteInfo describes an endpoint in the admin API.
+type RouteInfo struct {
+	Method    string   `json:"method"`
+	Path      string   `json:"path"`
+	Topic     string   `json:"topic,omitempty"`
+	Branches  []Branch `json:"aggregate,omitempty"`
+	Timeout   int64    `json:"timeout"` // In Millisecond
+	Async     bool     `json:"async,omitempty"`
+	Listeners []string `json:"listeners,omitempty"`
+}
+
+// ErrorSample is a request the proxy responded to with 5xx.
+type ErrorSample struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Endpoint string    `json:"endpoint"`
+	Topic    string    `json:"topic"`
+	Status   int       `json:"status"`
+	Duration int64     `json:"duration"` // In Millisecond
+}
+
+type inFlightInfo struct {
+	InFlightRequest
+	Age int64 `json:"age"` // In Millisecond
+}
+
+// ServeAdmin starts the admin API server. Stop closes it after the proxy is drained.
+func (pxy *Proxy) ServeAdmin() error {
+	admin := pxy.Admin
+	if admin == nil {
+		return ErrNoAdmin
+	}
+	if admin.Token == "" && admin.Auth == nil {
+		return ErrAdminAuth
+	}
+
+	ln, err := admin.Listener.listen()
+	if err != nil {
+		return ListenerError{admin.Listener.Name, err}
+	}
+
+	srv := &http.Server{Handler: pxy.adminRouter()}
+	admin.mu.Lock()
+	admin.server = srv
+	admin.mu.Unlock()
+
+	return srv.Serve(ln)
+}
+
+func (pxy *Proxy) closeAdmin() {
+	if pxy.Admin == nil {
+		return
+	}
+
+	pxy.Admin.mu.Lock()
+	srv := pxy.Admin.server
+	pxy.Admin.mu.Unlock()
+	if srv != nil {
+		srv.Close()
+	}
+}
+
+func (pxy *Proxy) adminRouter() *httprouter.Router {
+	router := httprouter.New()
+	router.GET("/routes", pxy.adminAuth(pxy.adminRoutes))
+	router.GET("/inflight", pxy.adminAuth(pxy.adminInFlight))
+	router.GET("/state", pxy.adminAuth(pxy.adminState))
+	router.GET("/errors", pxy.adminAuth(pxy.adminErrors))
+	router.GET("/debug", pxy.adminAuth(pxy.adminDebug))
+	router.POST("/debug", pxy.adminAuth(pxy.adminDebug))
+	router.DELETE("/debug", pxy.adminAuth(pxy.adminDebug))
+	return router
+}
+
+func (pxy *Proxy) adminAuth(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if !pxy.Admin.authenticated(r) {
+			pxy.Requests.Printf("admin %v:%v, status: %v", r.Method, r.URL.Path, http.StatusUnauthorized)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h(w, r, p)
+	}
+}
+
+func (a *Admin) authenticated(r *http.Request) bool {
+	if a.Auth != nil {
+		return a.Auth(r)
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := header[len("Bearer "):]
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
+
+func (pxy *Proxy) adminRoutes(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	routes := []RouteInfo{}
+	for _, ep := range pxy.Eps {
+		route := RouteInfo{
+			Method:    ep.Method,
+			Path:      ep.Path,
+			Timeout:   int64(pxy.endpointTimeout(ep) / time.Millisecond),
+			Async:     ep.Async,
+			Listeners: ep.Listeners,
+		}
+		if len(ep.Aggregate) > 0 {
+			route.Branches = ep.Aggregate
+		} else {
+			route.Topic = ep.Topic
+		}
+		routes = append(routes, route)
+	}
+
+	writeAdmin(w, routes)
+}
+
+func (pxy *Proxy) adminInFlight(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	now := time.Now()
+	requests := []inFlightInfo{}
+	for _, req := range pxy.inFlight.list() {
+		requests = append(requests, inFlightInfo{req, int64(now.Sub(req.Started) / time.Millisecond)})
+	}
+
+	writeAdmin(w, requests)
+}
+
+func (pxy *Proxy) adminState(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	state := map[string]interface{}{
+		"ready":    pxy.Ready(),
+		"inFlight": pxy.InFlight(),
+	}
+	for name, f := range pxy.Admin.State {
+		state[name] = f()
+	}
+
+	writeAdmin(w, state)
+}
+
+func (pxy *Proxy) adminErrors(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	a := pxy.Admin
+	a.mu.Lock()
+	samples := make([]ErrorSample, len(a.errors))
+	copy(samples, a.errors)
+	a.mu.Unlock()
+
+	writeAdmin(w, samples)
+}
+
+func (pxy *Proxy) adminDebug(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	a := pxy.Admin
+	path := r.URL.Query().Get("path")
+	if r.Method != "GET" && path == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	a.mu.Lock()
+	if a.debugPaths == nil {
+		a.debugPaths = map[string]bool{}
+	}
+	switch r.Method {
+	case "POST":
+		a.debugPaths[path] = true
+	case "DELETE":
+		delete(a.debugPaths, path)
+	}
+	paths := []string{}
+	for path := range a.debugPaths {
+		paths = append(paths, path)
+	}
+	a.mu.Unlock()
+
+	sort.Strings(paths)
+	pxy.Requests.Printf("admin %v:%v, status: %v, path: %v", r.Method, r.URL.Path, http.StatusOK, path)
+	writeAdmin(w, paths)
+}
+
+func writeAdmin(w http.ResponseWriter, v interface{}) {
+	body, _ := json.Marshal(v)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(body)
+}
+
+// observe records the error samples and logs the requests with debug logging enabled.
+func (pxy *Proxy) observe(ep Endpoint, h httprouter.Handle) httprouter.Handle {
+	key := endpointKey(ep)
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		a := pxy.Admin
+		if a == nil {
+			h(w, r, p)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		h(sw, r, p)
+
+		topic := pxy.inFlight.topic(r)
+		if topic == "" {
+			topic = ep.Topic
+		}
+
+		a.mu.Lock()
+		debug := a.debugPaths[ep.Path] || a.debugPaths[r.URL.Path]
+		if sw.status >= http.StatusInternalServerError {
+			a.addError(ErrorSample{
+				Time:     start,
+				Method:   r.Method,
+				Path:     r.URL.Path,
+				Endpoint: key,
+				Topic:    topic,
+				Status:   sw.status,
+				Duration: int64(time.Since(start) / time.Millisecond),
+			})
+		}
+		a.mu.Unlock()
+
+		if debug {
+			pxy.Debugger.Printf(
+				"%v:%v, endpoint: %v, topic: %v, status: %v, duration: %v, request headers: %v, response headers: %v",
+				r.Method, r.URL, key, topic, sw.status, time.Since(start), redactHeaders(r.Header), redactHeaders(sw.Header()),
+			)
+		}
+	}
+}
+
+// redactHeaders returns a copy of the headers without the credential values.
+func redactHeaders(header http.Header) http.Header {
+	redacted := make(http.Header, len(header))
+	for k, v := range header {
+		switch http.CanonicalHeaderKey(k) {
+		case "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie":
+			v = []string{"REDACTED"}
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// addError keeps the ErrorSamples recent errors, a.mu should be locked.
+func (a *Admin) addError(sample ErrorSample) {
+	size := a.ErrorSamples
+	if size <= 0 {
+		size = defaultErrorSamples
+	}
+
+	a.errors = append(a.errors, sample)
+	if len(a.errors) > size {
+		a.errors = a.errors[len(a.errors)-size:]
+	}
+}
+
+// statusWriter keeps the response status code.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
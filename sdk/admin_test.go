@@ -0,0 +1,233 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+func newAdminProxy(t *testing.T, release chan struct{}) *Proxy {
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("ok", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200})
+		w.Write(msg)
+	})
+	service.HandleFunc("broken.1", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 502})
+		w.Write(msg)
+	})
+	service.HandleFunc("slow.1", func(w mrpc.TopicWriter, data []byte) {
+		<-release
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200})
+		w.Write(msg)
+	})
+
+	pxy, _ := New(":80", service)
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.Debugger = &MockLogger{}
+	pxy.Admin = &Admin{
+		Token: "secret",
+		State: map[string]func() interface{}{"limiter": func() interface{} { return "open" }},
+	}
+	err := pxy.Handle(
+		Endpoint{Path: "/ok", Method: "GET", Topic: "service.ok", KeepAlive: 500},
+		Endpoint{Path: "/broken/:id", Method: "GET", Topic: "service.broken.{{.id}}"},
+		Endpoint{Path: "/slow/:id", Method: "GET", Topic: "service.slow.{{.id}}", Listeners: []string{"internal"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pxy.setupRouter()
+
+	return pxy
+}
+
+func adminRequest(pxy *Proxy, method, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	pxy.adminRouter().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAdminAuth(t *testing.T) {
+	cases := []struct {
+		auth   func(r *http.Request) bool
+		header string
+		status int
+	}{
+		{status: 401},
+		{header: "Bearer other", status: 401},
+		{header: "Bearer secret", status: 200},
+		{header: "secret", status: 401},
+		{header: "Basic secret", status: 401},
+		{auth: func(r *http.Request) bool { return r.Header.Get("X-Admin") == "yes" }, header: "Bearer secret", status: 401},
+		{auth: func(r *http.Request) bool { return true }, status: 200},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			pxy := newAdminProxy(t, nil)
+			pxy.Admin.Auth = tc.auth
+
+			req := httptest.NewRequest("GET", "/routes", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			pxy.adminRouter().ServeHTTP(rr, req)
+
+			if rr.Code != tc.status {
+				t.Errorf("Unexpected status: got %v want %v", rr.Code, tc.status)
+			}
+		})
+	}
+}
+
+func TestAdminNotOnPublicRouter(t *testing.T) {
+	pxy := newAdminProxy(t, nil)
+
+	for _, path := range []string{"/routes", "/inflight", "/state", "/errors", "/debug"} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		pxy.ServeHTTP(rr, req)
+		if rr.Code != 404 {
+			t.Errorf("Unexpected status for %v: %v", path, rr.Code)
+		}
+	}
+}
+
+func TestServeAdminErrors(t *testing.T) {
+	pxy := newAdminProxy(t, nil)
+	pxy.Admin.Token = ""
+	if err := pxy.ServeAdmin(); err != ErrAdminAuth {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	pxy.Admin = nil
+	if err := pxy.ServeAdmin(); err != ErrNoAdmin {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	pxy := newAdminProxy(t, nil)
+
+	routes := []RouteInfo{}
+	if err := json.Unmarshal(adminRequest(pxy, "GET", "/routes").Body.Bytes(), &routes); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(routes) != 3 {
+		t.Fatalf("Unexpected routes: %+v", routes)
+	}
+	if r := routes[0]; r.Path != "/ok" || r.Topic != "service.ok" || r.Timeout != 500 {
+		t.Errorf("Unexpected route: %+v", r)
+	}
+	if r := routes[2]; r.Topic != "service.slow.{{.id}}" || r.Timeout != 1000 || r.Listeners[0] != "internal" {
+		t.Errorf("Unexpected route: %+v", r)
+	}
+}
+
+func TestAdminInFlightAndState(t *testing.T) {
+	release := make(chan struct{})
+	pxy := newAdminProxy(t, release)
+
+	router, err := pxy.listenerRouter("internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		pxy.serveRouter(router, httptest.NewRecorder(), httptest.NewRequest("GET", "/slow/1", nil))
+		close(done)
+	}()
+	for len(pxy.InFlight()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	requests := []inFlightInfo{}
+	if err := json.Unmarshal(adminRequest(pxy, "GET", "/inflight").Body.Bytes(), &requests); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("Unexpected in-flight requests: %+v", requests)
+	}
+	if r := requests[0]; r.Endpoint != "GET /slow/:id" || r.Path != "/slow/1" || r.Topic != "service.slow.1" || r.Age < 10 {
+		t.Errorf("Unexpected in-flight request: %+v", r)
+	}
+
+	state := map[string]interface{}{}
+	if err := json.Unmarshal(adminRequest(pxy, "GET", "/state").Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+	if state["ready"] != true || state["limiter"] != "open" {
+		t.Errorf("Unexpected state: %v", state)
+	}
+	if inFlight := state["inFlight"].(map[string]interface{}); inFlight["GET /slow/:id"] != 1.0 {
+		t.Errorf("Unexpected in-flight counts: %v", inFlight)
+	}
+
+	close(release)
+	<-done
+}
+
+func TestAdminErrorsAndDebug(t *testing.T) {
+	pxy := newAdminProxy(t, nil)
+	pxy.Admin.ErrorSamples = 2
+
+	rr := adminRequest(pxy, "POST", "/debug?path=/broken/:id")
+	if rr.Code != 200 || rr.Body.String() != `["/broken/:id"]` {
+		t.Errorf("Unexpected debug response: %v %v", rr.Code, rr.Body.String())
+	}
+	if rr := adminRequest(pxy, "POST", "/debug"); rr.Code != 400 {
+		t.Errorf("Unexpected status without path: %v", rr.Code)
+	}
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/broken/1", nil)
+		req.Header.Set("Authorization", "Bearer user-token")
+		req.Header.Set("Cookie", "session=user-session")
+		pxy.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	pxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ok", nil))
+
+	samples := []ErrorSample{}
+	if err := json.Unmarshal(adminRequest(pxy, "GET", "/errors").Body.Bytes(), &samples); err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("Unexpected samples: %+v", samples)
+	}
+	if s := samples[0]; s.Endpoint != "GET /broken/:id" || s.Topic != "service.broken.1" || s.Status != 502 {
+		t.Errorf("Unexpected sample: %+v", s)
+	}
+
+	debug := pxy.Debugger.(*MockLogger)
+	if len(debug.storage) != 3 || !strings.Contains(debug.storage[0], "topic: service.broken.1, status: 502") {
+		t.Errorf("Unexpected debug log: %v", debug.storage)
+	}
+	if strings.Contains(debug.storage[0], "user-") || !strings.Contains(debug.storage[0], "Authorization:[REDACTED]") {
+		t.Errorf("Credentials not redacted: %v", debug.storage[0])
+	}
+
+	rr = adminRequest(pxy, "DELETE", "/debug?path=/broken/:id")
+	if rr.Body.String() != `[]` {
+		t.Errorf("Unexpected debug response: %v", rr.Body.String())
+	}
+	pxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/broken/1", nil))
+	if len(debug.storage) != 3 {
+		t.Errorf("Unexpected debug log: %v", debug.storage)
+	}
+}
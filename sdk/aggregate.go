@@ -0,0 +1,213 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/miracl/mrpcproxy"
+)
+
+// Branch is a single topic of an aggregate endpoint.
+type Branch struct {
+	Name     string `json:"name"`
+	Topic    string `json:"topic"`
+	Required bool   `json:"required"` // The whole request fails if the branch fails
+}
+
+// BranchResult is the part of the aggregate response coming from a single branch.
+type BranchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+type branchTemplate struct {
+	Branch
+	tmpl *template.Template
+}
+
+// getAggregateHandler returns a handler that requests all the endpoint branches in
+// parallel and responds with JSON object with the branch results keyed by the branch
+// name.
+//
+// The response status is 200 when at least one branch succeeded and no required branch
+// failed, otherwise it's 502.
+func (pxy *Proxy) getAggregateHandler(ep Endpoint) (httprouter.Handle, error) {
+	if err := validateBranches(ep.Aggregate); err != nil {
+		return nil, EndpointError{ep.Method, ep.Path, err}
+	}
+	if ep.Async || ep.Cache != nil || ep.RequestTemplate != "" || ep.ResponseTemplate != "" {
+		return nil, EndpointError{ep.Method, ep.Path, ErrAggregateOption}
+	}
+
+	branches := make([]branchTemplate, len(ep.Aggregate))
+	for i, b := range ep.Aggregate {
+		tmpl, err := parseTopic(b.Name, b.Topic)
+		if err != nil {
+			return nil, err
+		}
+		branches[i] = branchTemplate{b, tmpl}
+	}
+
+	rules, err := newParamRules(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	validator, err := newRequestValidator(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if err := rules.check(p, r.URL.Query()); err != nil {
+			pxy.Debugger.Println(err)
+			pxy.Requests.Printf("%v:%v, status: %v", r.Method, r.URL.Path, http.StatusBadRequest)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		data := pxy.topicData(r, p, ep, rules)
+		topics := make([]string, len(branches))
+		for i, b := range branches {
+			var err error
+			topics[i], err = getTopic(b.tmpl, data)
+			if err != nil {
+				status := http.StatusInternalServerError
+				if _, ok := err.(TopicError); ok {
+					status = http.StatusBadRequest
+				}
+				pxy.Debugger.Println(err)
+				pxy.Requests.Printf("%v:%v, status: %v, topic: %v", r.Method, r.URL.Path, status, topics[i])
+				w.WriteHeader(status)
+				return
+			}
+		}
+
+		if pxy.Compression != nil {
+			if err := pxy.Compression.decompressRequest(r); err != nil {
+				status := decompressStatus(err)
+				pxy.Debugger.Println(err)
+				pxy.Requests.Printf("%v:%v, status: %v, topic: %v", r.Method, r.URL.Path, status, strings.Join(topics, ","))
+				w.WriteHeader(status)
+				return
+			}
+		}
+
+		if !pxy.validateRequest(w, r, validator, strings.Join(topics, ",")) {
+			return
+		}
+
+		req, err := pxy.newRequestFromHTTP(r, p, ep)
+		if err != nil {
+			pxy.Debugger.Println(err)
+			pxy.Requests.Printf("%v:%v, status: %v, topic: %v", r.Method, r.URL.Path, http.StatusInternalServerError, strings.Join(topics, ","))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		pxy.Logger.Printf("%v:%v, remote Addr: %v, Id: %v", r.Method, r.URL.Path, req.IPAddress, req.RequestID)
+
+		ctx, cancel := context.WithTimeout(r.Context(), pxy.endpointTimeout(ep))
+		defer cancel()
+
+		results := make(map[string]*BranchResult, len(branches))
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for i, b := range branches {
+			wg.Add(1)
+			go func(name, topic string) {
+				defer wg.Done()
+				res := pxy.branchRequest(ctx, *req, topic)
+				mu.Lock()
+				results[name] = res
+				mu.Unlock()
+			}(b.Name, topics[i])
+		}
+		wg.Wait()
+
+		status := http.StatusBadGateway
+		for _, b := range branches {
+			res := results[b.Name]
+			if res.Error == "" && res.Status < 300 {
+				status = http.StatusOK
+				continue
+			}
+			if b.Required {
+				status = http.StatusBadGateway
+				break
+			}
+		}
+
+		body, err := json.Marshal(results)
+		if err != nil {
+			pxy.Debugger.Println(err)
+			pxy.Requests.Printf("%v:%v, status: %v, topic: %v", r.Method, r.URL.Path, http.StatusInternalServerError, strings.Join(topics, ","))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		pxy.setHeaders(w)
+		setEndpointHeaders(w, ep)
+		w.Header().Set("Content-Type", "application/json")
+
+		if pxy.ETags && status == http.StatusOK {
+			tag := etag(&mrpcproxy.Response{Msg: body})
+			w.Header().Set("ETag", tag)
+			if pxy.notModified(r, tag) {
+				pxy.setNotModifiedETag(w, r, ep, body)
+				pxy.Requests.Printf("%v:%v, status: %v, topic: %v, Id: %v", r.Method, r.URL.Path, http.StatusNotModified, strings.Join(topics, ","), req.RequestID)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		body = pxy.compress(w, r, ep, body)
+
+		pxy.Requests.Printf("%v:%v, status: %v, topic: %v, Id: %v", r.Method, r.URL.Path, status, strings.Join(topics, ","), req.RequestID)
+
+		w.WriteHeader(status)
+		if _, err := w.Write(body); err != nil {
+			pxy.Logger.Printf("writing to http.ResponseWriter failed: %v", err)
+		}
+	}, nil
+}
+
+// branchRequest makes the MRPC request for a single aggregate branch. The request is
+// passed by value so every branch has its own topic.
+func (pxy *Proxy) branchRequest(ctx context.Context, req mrpcproxy.Request, topic string) *BranchResult {
+	req.Topic = topic
+	mrpcReq, err := json.Marshal(&req)
+	if err != nil {
+		return &BranchResult{Status: http.StatusInternalServerError, Error: err.Error()}
+	}
+
+	resBytes, err := pxy.MRPCService.Request(ctx, topic, mrpcReq)
+	if err != nil {
+		if err == context.DeadlineExceeded {
+			return &BranchResult{Status: http.StatusRequestTimeout, Error: err.Error()}
+		}
+		return &BranchResult{Status: http.StatusBadGateway, Error: err.Error()}
+	}
+
+	res := &mrpcproxy.Response{}
+	if err := json.Unmarshal(resBytes, res); err != nil {
+		return &BranchResult{Status: http.StatusBadGateway, Error: ResponseError{err}.Error()}
+	}
+
+	result := &BranchResult{Status: res.Code}
+	if len(res.Msg) > 0 {
+		if json.Valid(res.Msg) {
+			result.Body = res.Msg
+		} else {
+			// Non JSON bodies are embedded as strings
+			result.Body, _ = json.Marshal(string(res.Msg))
+		}
+	}
+
+	return result
+}
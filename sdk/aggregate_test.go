@@ -0,0 +1,186 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+func TestAggregateHandler(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("user.1", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte(`{"name":"a"}`)})
+		w.Write(msg)
+	})
+	service.HandleFunc("text", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte("text")})
+		w.Write(msg)
+	})
+	service.HandleFunc("fail", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 500})
+		w.Write(msg)
+	})
+	service.HandleFunc("slow", func(w mrpc.TopicWriter, data []byte) {})
+
+	cases := []struct {
+		branches []Branch
+		status   int
+		results  map[string]*BranchResult
+	}{
+		{
+			branches: []Branch{
+				{Name: "user", Topic: "service.user.{{.id}}"},
+				{Name: "text", Topic: "service.text"},
+			},
+			status: http.StatusOK,
+			results: map[string]*BranchResult{
+				"user": {Status: 200, Body: json.RawMessage(`{"name":"a"}`)},
+				"text": {Status: 200, Body: json.RawMessage(`"text"`)},
+			},
+		},
+		{
+			branches: []Branch{
+				{Name: "user", Topic: "service.user.{{.id}}"},
+				{Name: "fail", Topic: "service.fail"},
+				{Name: "slow", Topic: "service.slow"},
+			},
+			status: http.StatusOK,
+			results: map[string]*BranchResult{
+				"user": {Status: 200, Body: json.RawMessage(`{"name":"a"}`)},
+				"fail": {Status: 500},
+				"slow": {Status: 408, Error: "context deadline exceeded"},
+			},
+		},
+		{
+			branches: []Branch{
+				{Name: "user", Topic: "service.user.{{.id}}"},
+				{Name: "fail", Topic: "service.fail", Required: true},
+			},
+			status: http.StatusBadGateway,
+			results: map[string]*BranchResult{
+				"user": {Status: 200, Body: json.RawMessage(`{"name":"a"}`)},
+				"fail": {Status: 500},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			pxy, _ := New(":80", service)
+			pxy.Logger = &MockLogger{}
+			pxy.Requests = &MockLogger{}
+
+			h, err := pxy.getTopicHandler(Endpoint{
+				Path:      "/home/:id",
+				Method:    "GET",
+				KeepAlive: 10,
+				Aggregate: tc.branches,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, _ := http.NewRequest("GET", "/home/1", nil)
+			rr := httptest.NewRecorder()
+			h(rr, req, httprouter.Params{{Key: "id", Value: "1"}})
+
+			if rr.Code != tc.status {
+				t.Errorf("Unexpected status: got %v want %v", rr.Code, tc.status)
+			}
+
+			results := map[string]*BranchResult{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results, tc.results) {
+				t.Errorf("Unexpected results: %v", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestParseMappingBranchNames(t *testing.T) {
+	_, err := ParseMapping([]byte(`{
+		"/home": {
+			"endpoints": [
+				{"method": "GET", "aggregate": [{"name": "a", "topic": "a"}, {"name": "a", "topic": "b"}]}
+			]
+		}
+	}`))
+	if err != ErrBranchName {
+		t.Errorf("Expected ErrBranchName, got: %v", err)
+	}
+}
+
+func TestAggregateEndpointErrors(t *testing.T) {
+	branches := []Branch{{Name: "a", Topic: "service.a"}, {Name: "b", Topic: "service.b"}}
+
+	cases := []struct {
+		ep  Endpoint
+		err string
+	}{
+		{
+			ep:  Endpoint{Aggregate: []Branch{{Name: "a", Topic: "service.a"}, {Name: "a", Topic: "service.b"}}},
+			err: ErrBranchName.Error(),
+		},
+		{ep: Endpoint{Aggregate: []Branch{{Topic: "service.a"}}}, err: ErrBranchName.Error()},
+		{ep: Endpoint{Aggregate: branches, Async: true}, err: ErrAggregateOption.Error()},
+		{ep: Endpoint{Aggregate: branches, Cache: &CacheConfig{}}, err: ErrAggregateOption.Error()},
+		{ep: Endpoint{Aggregate: branches, RequestTemplate: "{}"}, err: ErrAggregateOption.Error()},
+		{ep: Endpoint{Aggregate: branches, ResponseTemplate: "{}"}, err: ErrAggregateOption.Error()},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			service, _ := mrpc.NewService(mem.New())
+			pxy, _ := New(":80", service)
+			tc.ep.Path, tc.ep.Method = "/home", "GET"
+
+			err := pxy.Handle(tc.ep)
+			if err == nil || err.Error() != "GET /home: "+tc.err {
+				t.Errorf("Unexpected error: got %v want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestAggregateETag(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("a", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte(`{"name":"a"}`)})
+		w.Write(msg)
+	})
+
+	pxy, _ := New(":80", service)
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.ETags = true
+	h, err := pxy.getTopicHandler(Endpoint{Path: "/home", Method: "GET", Aggregate: []Branch{{Name: "a", Topic: "service.a"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest("GET", "/home", nil), nil)
+	tag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(tag, `"`) {
+		t.Fatalf("Unexpected response: %v %v", rr.Code, tag)
+	}
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/home", nil)
+	req.Header.Set("If-None-Match", tag)
+	h(rr, req, nil)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("Unexpected response: %v %v", rr.Code, rr.Body.String())
+	}
+}
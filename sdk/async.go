@@ -0,0 +1,401 @@
+package sdk
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpcproxy"
+)
+
+const (
+	defaultStatusPath = "/jobs/:id"
+	defaultJobTTL     = 1 * time.Hour
+
+	// JobPending is the status of a job that is published but has no result yet.
+	JobPending = "pending"
+	// JobDone is the status of a job with a stored result.
+	JobDone = "done"
+)
+
+var (
+	// ErrJobNotFound is returned by JobStore when there is no job with the given id.
+	ErrJobNotFound = errors.New("job not found")
+	// ErrAsyncSetup is returned when StatusPath or ResultTopic change after the first
+	// async endpoint is added.
+	ErrAsyncSetup = errors.New("StatusPath and ResultTopic can't change after the first async endpoint")
+	// ErrStatusPath is returned when StatusPath has no :id segment.
+	ErrStatusPath = errors.New("StatusPath must have an :id segment")
+)
+
+// Job is the state of a request made to an async endpoint. Auth is the endpoint auth,
+// the job status requires the same claims.
+type Job struct {
+	ID       string              `json:"id"`
+	Status   string              `json:"status"`
+	Topic    string              `json:"-"`
+	Auth     *EndpointAuth       `json:"-"`
+	Created  time.Time           `json:"-"`
+	Response *mrpcproxy.Response `json:"-"`
+}
+
+// JobStore keeps the state of async jobs until their results are requested.
+type JobStore interface {
+	Set(job *Job) error
+	Get(id string) (*Job, error)
+}
+
+// MemoryJobStore is an in-memory JobStore. Jobs are dropped after the TTL.
+type MemoryJobStore struct {
+	TTL time.Duration
+
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	swept time.Time
+}
+
+// NewMemoryJobStore creates new MemoryJobStore.
+func NewMemoryJobStore(ttl time.Duration) *MemoryJobStore {
+	return &MemoryJobStore{
+		TTL:  ttl,
+		jobs: map[string]*Job{},
+	}
+}
+
+// Set stores the job replacing the previous state with the same id.
+func (s *MemoryJobStore) Set(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired jobs at most once per TTL, Get ignores them in between
+	if time.Since(s.swept) > s.TTL {
+		for id, j := range s.jobs {
+			if time.Since(j.Created) > s.TTL {
+				delete(s.jobs, id)
+			}
+		}
+		s.swept = time.Now()
+	}
+
+	s.jobs[job.ID] = job
+	return nil
+}
+
+// Get returns the job or ErrJobNotFound.
+func (s *MemoryJobStore) Get(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || time.Since(job.Created) > s.TTL {
+		return nil, ErrJobNotFound
+	}
+
+	return job, nil
+}
+
+type asyncResponse struct {
+	*Job
+	StatusURL string `json:"statusUrl"`
+}
+
+// asyncSetup is the StatusPath and ResultTopic the async endpoints are served with.
+type asyncSetup struct {
+	statusPath, resultTopic string
+}
+
+// jobRequests maps the request ids of the pending jobs to the job ids, so the results
+// published to the result topic with the request id find their job.
+type jobRequests struct {
+	mu    sync.Mutex
+	jobs  map[string]jobRequest
+	swept time.Time
+}
+
+type jobRequest struct {
+	jobID   string
+	created time.Time
+}
+
+func (r *jobRequests) set(requestID, jobID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.jobs == nil {
+		r.jobs = map[string]jobRequest{}
+	}
+	// The results that never come are dropped with the jobs
+	if time.Since(r.swept) > defaultJobTTL {
+		for id, j := range r.jobs {
+			if time.Since(j.created) > defaultJobTTL {
+				delete(r.jobs, id)
+			}
+		}
+		r.swept = time.Now()
+	}
+
+	r.jobs[requestID] = jobRequest{jobID, time.Now()}
+}
+
+// take returns the job id of the request and forgets the request.
+func (r *jobRequests) take(requestID string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j, ok := r.jobs[requestID]
+	delete(r.jobs, requestID)
+	return j.jobID, ok
+}
+
+// handleAsync prepares the proxy for async endpoints. It's run once when the first
+// async endpoint is added, StatusPath and ResultTopic can't change afterwards.
+func (pxy *Proxy) handleAsync() error {
+	if pxy.async != nil {
+		if pxy.async.statusPath != pxy.StatusPath || pxy.async.resultTopic != pxy.ResultTopic {
+			return ErrAsyncSetup
+		}
+		return nil
+	}
+
+	if pxy.Jobs == nil {
+		pxy.Jobs = NewMemoryJobStore(defaultJobTTL)
+	}
+	if pxy.StatusPath == "" {
+		pxy.StatusPath = defaultStatusPath
+	}
+	if !hasIDSegment(pxy.StatusPath) {
+		return fmt.Errorf("%v: %w", pxy.StatusPath, ErrStatusPath)
+	}
+
+	if pxy.ResultTopic != "" {
+		if err := pxy.MRPCService.HandleFunc(pxy.ResultTopic, pxy.jobResultHandler); err != nil {
+			return err
+		}
+	}
+
+	// The job status is served on all the listeners, the jobs of the endpoints with
+	// auth require the same claims
+	if err := pxy.handle("GET", pxy.StatusPath, nil, pxy.jobStatusHandler); err != nil {
+		return err
+	}
+
+	pxy.async = &asyncSetup{pxy.StatusPath, pxy.ResultTopic}
+	return nil
+}
+
+// asyncRequest publishes the request without waiting for the result and responds with
+// 202 Accepted and the URL where the job status can be checked. The job id is random,
+// so the results of the endpoints without auth can't be guessed from the request ids.
+func (pxy *Proxy) asyncRequest(w http.ResponseWriter, r *http.Request, p httprouter.Params, ep Endpoint) error {
+	req, err := pxy.newRequestFromHTTP(r, p, ep)
+	if err != nil {
+		return err
+	}
+	jobID := newJobID()
+	if req.RequestID == "" {
+		req.RequestID = jobID
+	}
+
+	mrpcReq, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	job := &Job{
+		ID:      jobID,
+		Status:  JobPending,
+		Topic:   ep.Topic,
+		Auth:    ep.Auth,
+		Created: time.Now(),
+	}
+	if err := pxy.Jobs.Set(job); err != nil {
+		return err
+	}
+	pxy.jobRequests.set(req.RequestID, job.ID)
+
+	pxy.Logger.Printf("%v:%v, remote Addr: %v, Id: %v, job: %v, async", r.Method, r.URL.Path, req.IPAddress, req.RequestID, job.ID)
+
+	id := pxy.inFlight.start(&InFlightRequest{
+		Endpoint: endpointKey(ep),
+		Path:     r.URL.Path,
+		Topic:    ep.Topic,
+		Async:    true,
+		Started:  time.Now(),
+	})
+	go func() {
+		defer pxy.inFlight.done(id)
+		pxy.publishJob(job, req.RequestID, mrpcReq, pxy.endpointTimeout(ep))
+	}()
+
+	statusURL := pxy.jobStatusURL(job.ID)
+	body, err := json.Marshal(&asyncResponse{Job: job, StatusURL: statusURL})
+	if err != nil {
+		return err
+	}
+
+	pxy.setHeaders(w)
+	setEndpointHeaders(w, ep)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", statusURL)
+
+	pxy.Requests.Printf("%v:%v, status: %v, topic: %v, Id: %v", r.Method, r.URL.Path, http.StatusAccepted, ep.Topic, job.ID)
+
+	w.WriteHeader(http.StatusAccepted)
+	if _, err := w.Write(body); err != nil {
+		pxy.Logger.Printf("writing to http.ResponseWriter failed: %v", err)
+	}
+
+	return nil
+}
+
+// publishJob sends the job to the service. The service can respond straight away with
+// the result or with 202 and publish the result to the result topic later.
+func (pxy *Proxy) publishJob(job *Job, requestID string, msg []byte, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resBytes, err := pxy.MRPCService.Request(ctx, job.Topic, msg)
+	if err != nil {
+		if err != context.DeadlineExceeded {
+			pxy.Debugger.Printf("async job %v: %v", job.ID, err)
+		}
+		return
+	}
+
+	res := &mrpcproxy.Response{}
+	if err := json.Unmarshal(resBytes, res); err != nil {
+		pxy.Debugger.Println(ResponseError{err})
+		return
+	}
+	if res.Code == http.StatusAccepted {
+		return
+	}
+
+	if _, ok := pxy.jobRequests.take(requestID); !ok {
+		// The result is already published to the result topic
+		return
+	}
+	res.RequestID = requestID
+	pxy.storeJobResult(job.ID, res)
+}
+
+// jobResultHandler receives mrpcproxy.Response messages from the result topic.
+func (pxy *Proxy) jobResultHandler(_ mrpc.TopicWriter, data []byte) {
+	res := &mrpcproxy.Response{}
+	if err := json.Unmarshal(data, res); err != nil {
+		pxy.Debugger.Println(ResponseError{err})
+		return
+	}
+
+	jobID, ok := pxy.jobRequests.take(res.RequestID)
+	if !ok {
+		pxy.Debugger.Printf("async request %v: %v", res.RequestID, ErrJobNotFound)
+		return
+	}
+	pxy.storeJobResult(jobID, res)
+}
+
+func (pxy *Proxy) storeJobResult(jobID string, res *mrpcproxy.Response) {
+	job, err := pxy.Jobs.Get(jobID)
+	if err != nil {
+		pxy.Debugger.Printf("async job %v: %v", jobID, err)
+		return
+	}
+
+	if err := pxy.Jobs.Set(&Job{
+		ID:       job.ID,
+		Status:   JobDone,
+		Topic:    job.Topic,
+		Auth:     job.Auth,
+		Created:  job.Created,
+		Response: res,
+	}); err != nil {
+		pxy.Debugger.Printf("async job %v: %v", jobID, err)
+	}
+}
+
+// jobStatusHandler responds with 202 while the job is pending and with the stored
+// response when it's done.
+func (pxy *Proxy) jobStatusHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	pxy.setHeaders(w)
+
+	job, err := pxy.Jobs.Get(p.ByName("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == ErrJobNotFound {
+			status = http.StatusNotFound
+		} else {
+			pxy.Debugger.Println(err)
+		}
+		pxy.Requests.Printf("%v:%v, status: %v", r.Method, r.URL.Path, status)
+		w.WriteHeader(status)
+		return
+	}
+
+	if job.Auth != nil && len(job.Auth.Claims) > 0 && !pxy.authorized(r, job.Auth) {
+		pxy.Requests.Printf("%v:%v, status: %v, Id: %v", r.Method, r.URL.Path, http.StatusUnauthorized, job.ID)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if job.Status != JobDone {
+		body, _ := json.Marshal(&asyncResponse{Job: job, StatusURL: pxy.jobStatusURL(job.ID)})
+		w.Header().Set("Content-Type", "application/json")
+		pxy.Requests.Printf("%v:%v, status: %v, Id: %v", r.Method, r.URL.Path, http.StatusAccepted, job.ID)
+		w.WriteHeader(http.StatusAccepted)
+		if _, err := w.Write(body); err != nil {
+			pxy.Logger.Printf("writing to http.ResponseWriter failed: %v", err)
+		}
+		return
+	}
+
+	res := job.Response
+	pxy.setServiceHeaders(w, res.Headers)
+
+	pxy.Requests.Printf("%v:%v, status: %v, Id: %v", r.Method, r.URL.Path, res.Code, job.ID)
+
+	w.WriteHeader(res.Code)
+	if _, err := w.Write(res.Msg); err != nil {
+		pxy.Logger.Printf("writing to http.ResponseWriter failed: %v", err)
+	}
+}
+
+func (pxy *Proxy) jobStatusURL(id string) string {
+	segments := strings.Split(pxy.StatusPath, "/")
+	for i, s := range segments {
+		if s == ":id" {
+			segments[i] = id
+			break
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func hasIDSegment(path string) bool {
+	for _, s := range strings.Split(path, "/") {
+		if s == ":id" {
+			return true
+		}
+	}
+	return false
+}
+
+// newJobID returns a random job id, it's also the request id when the proxy GetID
+// doesn't generate ids.
+func newJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
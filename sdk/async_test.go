@@ -0,0 +1,273 @@
+package sdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+// notifyJobStore signals the ids of the done jobs.
+type notifyJobStore struct {
+	JobStore
+	done chan string
+}
+
+func (s notifyJobStore) Set(job *Job) error {
+	err := s.JobStore.Set(job)
+	if job.Status == JobDone {
+		s.done <- job.ID
+	}
+	return err
+}
+
+func TestAsyncEndpoint(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("sync", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte("done")})
+		w.Write(msg)
+	})
+	service.HandleFunc("accepted", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: http.StatusAccepted})
+		w.Write(msg)
+	})
+
+	pxy, _ := New(":80", service)
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.ResultTopic = "results"
+	jobs := notifyJobStore{NewMemoryJobStore(time.Minute), make(chan string, 1)}
+	pxy.Jobs = jobs
+
+	ids := []string{"req1", "req2"}
+	pxy.GetID = func() string {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+
+	if err := pxy.Handle(
+		Endpoint{Path: "/sync", Method: "POST", Topic: "service.sync", Async: true},
+		Endpoint{Path: "/accepted", Method: "POST", Topic: "service.accepted", Async: true},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path     string
+		status   int
+		body     string
+		complete func()
+	}{
+		{
+			path:   "/sync",
+			status: 200,
+			body:   "done",
+		},
+		{
+			path:   "/accepted",
+			status: 201,
+			body:   "created",
+			complete: func() {
+				msg, _ := json.Marshal(&mrpcproxy.Response{RequestID: "req2", Code: 201, Msg: []byte("created")})
+				pxy.jobResultHandler(nil, msg)
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", tc.path, nil)
+		pxy.router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("%v: unexpected status: %v", tc.path, rr.Code)
+		}
+
+		res := &asyncResponse{}
+		if err := json.Unmarshal(rr.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+		// The job ids are random, not the request ids
+		if len(res.ID) != 32 || res.Status != JobPending || res.StatusURL != "/jobs/"+res.ID {
+			t.Errorf("%v: unexpected response: %v", tc.path, rr.Body.String())
+		}
+		if rr.Header().Get("Location") != res.StatusURL {
+			t.Errorf("%v: unexpected Location header: %v", tc.path, rr.Header().Get("Location"))
+		}
+
+		if tc.complete != nil {
+			rr = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", res.StatusURL, nil)
+			pxy.router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusAccepted {
+				t.Errorf("%v: job should be pending, status: %v", tc.path, rr.Code)
+			}
+
+			tc.complete()
+		}
+		if id := <-jobs.done; id != res.ID {
+			t.Fatalf("%v: unexpected job done: %v", tc.path, id)
+		}
+
+		rr = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", res.StatusURL, nil)
+		pxy.router.ServeHTTP(rr, req)
+		if rr.Code != tc.status || rr.Body.String() != tc.body {
+			t.Errorf("%v: unexpected job result: %v %v", tc.path, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/jobs/missing", nil)
+	pxy.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status for missing job: %v", rr.Code)
+	}
+}
+
+func TestAsyncEndpointAuth(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("private", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte("secret")})
+		w.Write(msg)
+	})
+
+	pxy, _ := New(":80", service)
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.Claims = func(r *http.Request) map[string]interface{} {
+		return map[string]interface{}{"sub": r.Header.Get("X-User")}
+	}
+	jobs := notifyJobStore{NewMemoryJobStore(time.Minute), make(chan string, 1)}
+	pxy.Jobs = jobs
+	pxy.GetID = func() string { return "job1" }
+
+	ep := Endpoint{Path: "/private", Method: "POST", Topic: "service.private", Async: true, Auth: &EndpointAuth{Claims: []string{"sub"}}}
+	if err := pxy.Handle(ep); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/private", nil)
+	req.Header.Set("X-User", "alice")
+	pxy.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Unexpected status: %v", rr.Code)
+	}
+	statusURL := rr.Header().Get("Location")
+	<-jobs.done
+
+	cases := []struct {
+		user   string
+		status int
+	}{
+		{user: "", status: http.StatusUnauthorized},
+		{user: "alice", status: http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", statusURL, nil)
+		req.Header.Set("X-User", tc.user)
+		pxy.router.ServeHTTP(rr, req)
+		if rr.Code != tc.status {
+			t.Errorf("%q: unexpected status: got %v want %v", tc.user, rr.Code, tc.status)
+		}
+	}
+}
+
+func TestAsyncSetup(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	pxy, _ := New(":80", service)
+
+	if err := pxy.Handle(Endpoint{Path: "/a", Method: "POST", Topic: "service.a", Async: true}); err != nil {
+		t.Fatal(err)
+	}
+	if pxy.StatusPath != defaultStatusPath {
+		t.Errorf("Unexpected status path: %v", pxy.StatusPath)
+	}
+
+	pxy.ResultTopic = "results"
+	err := pxy.Handle(Endpoint{Path: "/b", Method: "POST", Topic: "service.b", Async: true})
+	if !errors.Is(err, ErrAsyncSetup) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestAsyncStatusPath(t *testing.T) {
+	cases := []struct {
+		path string
+		url  string
+		err  error
+	}{
+		{path: "/status/:id", url: "/status/job1"},
+		{path: "/jobs/:id/status", url: "/jobs/job1/status"},
+		{path: "/status/:job", err: ErrStatusPath},
+		{path: "/status/x:id", err: ErrStatusPath},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			service, _ := mrpc.NewService(mem.New())
+			pxy, _ := New(":80", service)
+			pxy.StatusPath = tc.path
+
+			err := pxy.Handle(Endpoint{Path: "/a", Method: "POST", Topic: "service.a", Async: true})
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if url := pxy.jobStatusURL("job1"); tc.err == nil && url != tc.url {
+				t.Errorf("Unexpected status URL: %v", url)
+			}
+		})
+	}
+}
+
+func TestAsyncResultUnknownRequest(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	pxy, _ := New(":80", service)
+	pxy.Debugger = &MockLogger{}
+	jobs := notifyJobStore{NewMemoryJobStore(time.Minute), make(chan string, 1)}
+	pxy.Jobs = jobs
+	jobs.Set(&Job{ID: "req1", Status: JobPending, Created: time.Now()})
+
+	// The job ids can't be completed with the request ids
+	msg, _ := json.Marshal(&mrpcproxy.Response{RequestID: "req1", Code: 200})
+	pxy.jobResultHandler(nil, msg)
+	if job, _ := jobs.Get("req1"); job.Status != JobPending {
+		t.Errorf("Unexpected job status: %v", job.Status)
+	}
+}
+
+func TestMemoryJobStoreTTL(t *testing.T) {
+	s := NewMemoryJobStore(time.Minute)
+	s.Set(&Job{ID: "old", Created: time.Now().Add(-time.Hour)})
+	s.Set(&Job{ID: "a", Created: time.Now()})
+
+	if _, err := s.Get("a"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := s.Get("old"); err != ErrJobNotFound {
+		t.Errorf("Expected ErrJobNotFound, got: %v", err)
+	}
+
+	// The first Set sweeps, the next sweep is after the TTL
+	s.Set(&Job{ID: "expired", Created: time.Now().Add(-time.Hour)})
+	s.Set(&Job{ID: "b", Created: time.Now()})
+	if _, ok := s.jobs["expired"]; !ok {
+		t.Errorf("Swept before the TTL")
+	}
+	s.swept = time.Now().Add(-time.Hour)
+	s.Set(&Job{ID: "c", Created: time.Now()})
+	if _, ok := s.jobs["expired"]; ok || len(s.jobs) != 3 {
+		t.Errorf("Unexpected jobs after the sweep: %v", len(s.jobs))
+	}
+}
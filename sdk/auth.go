@@ -0,0 +1,94 @@
+package sdk
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// EndpointAuth are the caller requirements of the endpoint. Claims are the names of the
+// Proxy.Claims the caller must have, the request is rejected with 401 otherwise.
+type EndpointAuth struct {
+	Claims []string `json:"claims,omitempty"`
+}
+
+// withAuth rejects the requests of the callers without the endpoint claims.
+func (pxy *Proxy) withAuth(ep Endpoint, h httprouter.Handle) httprouter.Handle {
+	if ep.Auth == nil || len(ep.Auth.Claims) == 0 {
+		return h
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if !pxy.authorized(r, ep.Auth) {
+			pxy.setHeaders(w)
+			pxy.Requests.Printf("%v:%v, status: %v, topic: %v", r.Method, r.URL.Path, http.StatusUnauthorized, ep.Topic)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h(w, r, p)
+	}
+}
+
+func (pxy *Proxy) authorized(r *http.Request, auth *EndpointAuth) bool {
+	if pxy.Claims == nil {
+		return false
+	}
+
+	claims := pxy.Claims(r)
+	for _, name := range auth.Claims {
+		if v, ok := claims[name]; !ok || v == nil || v == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// removeGatewayHeaders removes the GatewayHeaders set by the clients, only the trusted
+// proxies set them.
+func (pxy *Proxy) removeGatewayHeaders(r *http.Request) {
+	if len(pxy.GatewayHeaders) == 0 || pxy.trustedProxy(r) {
+		return
+	}
+	for _, h := range pxy.GatewayHeaders {
+		r.Header.Del(h)
+	}
+}
+
+func (pxy *Proxy) trustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// Unix socket
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, n := range pxy.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies parses the IP addresses and the CIDR ranges of the trusted proxies.
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, p := range proxies {
+		if ip := net.ParseIP(p); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %v", p)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
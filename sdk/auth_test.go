@@ -0,0 +1,126 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+func TestEndpointAuth(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("a", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{
+			Code:    200,
+			Headers: http.Header{"Cache-Control": {"max-age=60"}},
+		})
+		w.Write(msg)
+	})
+
+	pxy, _ := New(":80", service, func(pxy *Proxy) error {
+		pxy.Headers = map[string]string{"Cache-Control": "no-store", "X-Api": "v1"}
+		pxy.Claims = func(r *http.Request) map[string]interface{} {
+			claims := map[string]interface{}{}
+			if sub := r.Header.Get("X-User-Id"); sub != "" {
+				claims["sub"] = sub
+			}
+			return claims
+		}
+		pxy.GatewayHeaders = []string{"X-User-Id"}
+		pxy.TrustedProxies, _ = ParseTrustedProxies([]string{"192.0.2.1", "2001:db8::/32"})
+		return nil
+	})
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.Debugger = &MockLogger{}
+	pxy.Handle(
+		Endpoint{Path: "/public", Method: "GET", Topic: "service.a", Headers: map[string]string{"X-Api": "v2"}},
+		Endpoint{Path: "/private", Method: "GET", Topic: "service.a", Auth: &EndpointAuth{Claims: []string{"sub"}}},
+		Endpoint{Path: "/admin", Method: "GET", Topic: "service.a", Auth: &EndpointAuth{Claims: []string{"sub", "admin"}}},
+	)
+	pxy.setupRouter()
+
+	cases := []struct {
+		path   string
+		user   string
+		remote string
+		status int
+		api    string
+	}{
+		{path: "/public", status: 200, api: "v2"},
+		{path: "/private", status: 401, api: "v1"},
+		{path: "/private", user: "alice", status: 200, api: "v1"},
+		{path: "/admin", user: "alice", status: 401, api: "v1"},
+		{path: "/private", user: "alice", remote: "[2001:db8::1]:1234", status: 200, api: "v1"},
+		{path: "/private", user: "alice", remote: "198.51.100.7:1234", status: 401, api: "v1"},
+		{path: "/private", user: "alice", remote: "@", status: 401, api: "v1"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.path, nil)
+			if tc.user != "" {
+				r.Header.Set("X-User-Id", tc.user)
+			}
+			if tc.remote != "" {
+				r.RemoteAddr = tc.remote
+			}
+			rr := httptest.NewRecorder()
+			pxy.ServeHTTP(rr, r)
+
+			if rr.Code != tc.status {
+				t.Errorf("Unexpected status: got %v want %v", rr.Code, tc.status)
+			}
+			if rr.Header().Get("X-Api") != tc.api {
+				t.Errorf("Unexpected X-Api: got %q want %q", rr.Header().Get("X-Api"), tc.api)
+			}
+			if tc.status == 200 && rr.Header().Get("Cache-Control") != "max-age=60" {
+				t.Errorf("Service header not set: %v", rr.Header())
+			}
+		})
+	}
+
+	pxy.Claims = nil
+	rr := httptest.NewRecorder()
+	pxy.ServeHTTP(rr, httptest.NewRequest("GET", "/public", nil))
+	if rr.Code != 200 {
+		t.Errorf("Unexpected status without claims: %v", rr.Code)
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	cases := []struct {
+		proxies []string
+		nets    []string
+		err     string
+	}{
+		{proxies: []string{"10.0.0.0/8", "192.0.2.1", "::1"}, nets: []string{"10.0.0.0/8", "192.0.2.1/32", "::1/128"}},
+		{proxies: []string{"gateway"}, err: "invalid trusted proxy gateway"},
+		{proxies: []string{"10.0.0.0/33"}, err: "invalid trusted proxy 10.0.0.0/33"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			nets, err := ParseTrustedProxies(tc.proxies)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("Unexpected error: got %v want %v", err, tc.err)
+				}
+				return
+			}
+			if len(nets) != len(tc.nets) {
+				t.Fatalf("Unexpected nets: %v", nets)
+			}
+			for i, n := range nets {
+				if n.String() != tc.nets[i] {
+					t.Errorf("Unexpected net: got %v want %v", n, tc.nets[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package sdk
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/miracl/mrpcproxy"
+)
+
+const defaultCacheSize = 1024
+
+// CacheConfig enables response caching for GET endpoints.
+//
+// The cache key is built from the method, the path, the rendered topic, the Query
+// parameters (all of them if not set) and the Vary request headers. Responses are cached
+// for TTL unless the service sets Cache-Control max-age or forbids caching. Responses
+// with Vary headers not in the key aren't cached.
+type CacheConfig struct {
+	TTL   int      `json:"ttl"` // In Millisecond
+	Query []string `json:"query,omitempty"`
+	Vary  []string `json:"vary,omitempty"`
+}
+
+// Cache is the storage of the cached responses.
+type Cache interface {
+	Get(key string) (*mrpcproxy.Response, bool)
+	Set(key string, res *mrpcproxy.Response, ttl time.Duration)
+}
+
+// LRUCache is an in-memory Cache evicting the least recently used responses.
+type LRUCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type lruEntry struct {
+	key     string
+	res     *mrpcproxy.Response
+	expires time.Time
+}
+
+// NewLRUCache creates new LRUCache holding up to size responses.
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{
+		size:    size,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// Get returns the cached response if it's not expired.
+func (c *LRUCache) Get(key string) (*mrpcproxy.Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return entry.res, true
+}
+
+// Set stores the response for the ttl.
+func (c *LRUCache) Set(key string, res *mrpcproxy.Response, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &lruEntry{key, res, time.Now().Add(ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		el := c.order.Back()
+		c.order.Remove(el)
+		delete(c.entries, el.Value.(*lruEntry).key)
+	}
+}
+
+// flight is an in-progress MRPC request shared by the concurrent identical requests.
+type flight struct {
+	wg     sync.WaitGroup
+	res    *mrpcproxy.Response
+	err    error
+	shared bool // The response is cacheable
+}
+
+// cachedRequest returns the cached response or makes the MRPC request. Concurrent
+// requests with the same key wait for a single MRPC request, it isn't canceled when the
+// first caller goes away. The waiters get the response only when it's cacheable,
+// otherwise they make their own request.
+func (pxy *Proxy) cachedRequest(r *http.Request, p httprouter.Params, ep Endpoint) (*mrpcproxy.Response, bool, error) {
+	key := cacheKey(r, ep)
+	if res, ok := pxy.Cache.Get(key); ok {
+		return copyResponse(res), true, nil
+	}
+
+	pxy.flightsMu.Lock()
+	if f, ok := pxy.flights[key]; ok {
+		pxy.flightsMu.Unlock()
+		f.wg.Wait()
+		if !f.shared {
+			res, err := pxy.mrpcRequest(r, p, ep)
+			return res, false, err
+		}
+		return copyResponse(f.res), false, nil
+	}
+	f := &flight{}
+	f.wg.Add(1)
+	if pxy.flights == nil {
+		pxy.flights = map[string]*flight{}
+	}
+	pxy.flights[key] = f
+	pxy.flightsMu.Unlock()
+
+	// Detached from the caller, still bounded by the endpoint timeout
+	f.res, f.err = pxy.mrpcRequest(r.WithContext(context.Background()), p, ep)
+	if f.err == nil && f.res.Code == http.StatusOK {
+		if ttl := cacheTTL(ep.Cache, f.res.Headers); ttl > 0 {
+			pxy.Cache.Set(key, f.res, ttl)
+			f.shared = true
+		}
+	}
+
+	pxy.flightsMu.Lock()
+	delete(pxy.flights, key)
+	pxy.flightsMu.Unlock()
+	f.wg.Done()
+
+	if f.err != nil {
+		return nil, false, f.err
+	}
+	return copyResponse(f.res), false, nil
+}
+
+// cacheKey returns the key of the request, ep.Topic is the rendered topic so the values
+// from the topic headers and claims are in the key.
+func cacheKey(r *http.Request, ep Endpoint) string {
+	query := r.URL.Query()
+	if ep.Cache.Query != nil {
+		selected := url.Values{}
+		for _, q := range ep.Cache.Query {
+			if v, ok := query[q]; ok {
+				selected[q] = v
+			}
+		}
+		query = selected
+	}
+
+	var key strings.Builder
+	key.WriteString(ep.Method)
+	key.WriteString(" ")
+	key.WriteString(r.URL.Path)
+	key.WriteString("?")
+	key.WriteString(query.Encode()) // Sorted by key
+	key.WriteString("\n")
+	key.WriteString(ep.Topic)
+
+	vary := append([]string{}, ep.Cache.Vary...)
+	sort.Strings(vary)
+	for _, h := range vary {
+		key.WriteString("\n")
+		key.WriteString(http.CanonicalHeaderKey(h))
+		key.WriteString(": ")
+		key.WriteString(strings.Join(r.Header[http.CanonicalHeaderKey(h)], ","))
+	}
+
+	return key.String()
+}
+
+// cacheTTL returns the TTL from the response Cache-Control or the endpoint default.
+func cacheTTL(cfg *CacheConfig, headers http.Header) time.Duration {
+	if !varyKeyed(cfg.Vary, headers) {
+		return 0
+	}
+
+	ttl := time.Duration(cfg.TTL) * time.Millisecond
+	shared := false
+	for _, v := range headers["Cache-Control"] {
+		for _, directive := range strings.Split(v, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			name, value := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, value = directive[:i], directive[i+1:]
+			}
+
+			switch name {
+			case "no-store", "no-cache", "private":
+				return 0
+			case "s-maxage", "max-age":
+				s, err := strconv.Atoi(value)
+				if err != nil || (shared && name == "max-age") {
+					continue
+				}
+				// s-maxage takes precedence in shared caches
+				shared = name == "s-maxage"
+				ttl = time.Duration(s) * time.Second
+			}
+		}
+	}
+
+	return ttl
+}
+
+// varyKeyed checks the response Vary headers are in the cache key.
+func varyKeyed(vary []string, headers http.Header) bool {
+	keyed := map[string]bool{}
+	for _, h := range vary {
+		keyed[http.CanonicalHeaderKey(h)] = true
+	}
+
+	for _, v := range headers["Vary"] {
+		for _, h := range strings.Split(v, ",") {
+			h = strings.TrimSpace(h)
+			if h == "*" || (h != "" && !keyed[http.CanonicalHeaderKey(h)]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// copyResponse returns a copy so the handlers can't change the cached response.
+func copyResponse(res *mrpcproxy.Response) *mrpcproxy.Response {
+	c := *res
+	c.Msg = append([]byte(nil), res.Msg...)
+	c.Headers = res.Headers.Clone()
+	return &c
+}
@@ -0,0 +1,273 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(2)
+	c.Set("a", &mrpcproxy.Response{Msg: []byte("a")}, time.Minute)
+	c.Set("b", &mrpcproxy.Response{Msg: []byte("b")}, time.Minute)
+	c.Get("a")
+	c.Set("c", &mrpcproxy.Response{Msg: []byte("c")}, time.Millisecond)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("Least recently used response not evicted")
+	}
+	if res, ok := c.Get("a"); !ok || string(res.Msg) != "a" {
+		t.Error("Recently used response evicted")
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := c.Get("c"); ok {
+		t.Error("Expired response returned")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	cases := []struct {
+		cacheControl []string
+		vary         []string
+		ttl          time.Duration
+	}{
+		{nil, nil, time.Second},
+		{[]string{"max-age=60"}, nil, time.Minute},
+		{[]string{"public, max-age=60, s-maxage=120"}, nil, 2 * time.Minute},
+		{[]string{"s-maxage=120", "max-age=60"}, nil, 2 * time.Minute},
+		{[]string{"max-age=60, no-store"}, nil, 0},
+		{[]string{"private"}, nil, 0},
+		{[]string{"max-age=abc"}, nil, time.Second},
+		{nil, []string{"accept-language"}, time.Second},
+		{nil, []string{"Accept-Language, Cookie"}, 0},
+		{nil, []string{"*"}, 0},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			cfg := &CacheConfig{TTL: 1000, Vary: []string{"Accept-Language"}}
+			ttl := cacheTTL(cfg, http.Header{"Cache-Control": tc.cacheControl, "Vary": tc.vary})
+			if ttl != tc.ttl {
+				t.Errorf("Unexpected TTL: got %v want %v", ttl, tc.ttl)
+			}
+		})
+	}
+}
+
+func TestCachedHandler(t *testing.T) {
+	var calls int32
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("ref", func(w mrpc.TopicWriter, data []byte) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+
+		req := &mrpcproxy.Request{}
+		json.Unmarshal(data, req)
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte(req.Params.Get("lang"))})
+		w.Write(msg)
+	})
+
+	pxy, _ := New(":80", service)
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.Handle(Endpoint{
+		Path:   "/ref",
+		Method: "GET",
+		Topic:  "service.ref",
+		Cache:  &CacheConfig{TTL: 60000, Query: []string{"lang"}, Vary: []string{"Accept-Language"}},
+	})
+
+	get := func(url, lang string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Accept-Language", lang)
+		rr := httptest.NewRecorder()
+		pxy.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Concurrent misses make a single request
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// A late request is served from the cache
+			rr := get("/ref?lang=en", "en")
+			if rr.Code != http.StatusOK || rr.Body.String() != "en" || rr.Header().Get("X-Cache") == "" {
+				t.Errorf("Unexpected response: %v %v %v", rr.Code, rr.Body.String(), rr.Header())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c := atomic.LoadInt32(&calls); c != 1 {
+		t.Errorf("Unexpected MRPC requests count: %v", c)
+	}
+
+	cases := []struct {
+		url   string
+		lang  string
+		cache string
+		calls int32
+	}{
+		{"/ref?lang=en", "en", "HIT", 1},
+		{"/ref?lang=en&other=1", "en", "HIT", 1},
+		{"/ref?lang=de", "en", "MISS", 2},
+		{"/ref?lang=en", "de", "MISS", 3},
+		{"/ref?lang=en", "de", "HIT", 3},
+	}
+
+	for i, tc := range cases {
+		rr := get(tc.url, tc.lang)
+		if rr.Header().Get("X-Cache") != tc.cache {
+			t.Errorf("Case %v: unexpected X-Cache: got %v want %v", i, rr.Header().Get("X-Cache"), tc.cache)
+		}
+		if c := atomic.LoadInt32(&calls); c != tc.calls {
+			t.Errorf("Case %v: unexpected MRPC requests count: got %v want %v", i, c, tc.calls)
+		}
+	}
+}
+
+func TestCachedHandlerTopic(t *testing.T) {
+	var calls int32
+	service, _ := mrpc.NewService(mem.New())
+	for _, tenant := range []string{"a", "b"} {
+		tenant := tenant
+		service.HandleFunc("ref."+tenant, func(w mrpc.TopicWriter, data []byte) {
+			atomic.AddInt32(&calls, 1)
+			msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte(tenant)})
+			w.Write(msg)
+		})
+	}
+
+	pxy, _ := New(":80", service)
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.Handle(Endpoint{
+		Path:         "/ref",
+		Method:       "GET",
+		Topic:        "service.ref.{{.Header.Tenant}}",
+		TopicHeaders: []string{"Tenant"},
+		Cache:        &CacheConfig{TTL: 60000},
+	})
+
+	for i, tenant := range []string{"a", "b", "a"} {
+		req := httptest.NewRequest("GET", "/ref", nil)
+		req.Header.Set("Tenant", tenant)
+		rr := httptest.NewRecorder()
+		pxy.router.ServeHTTP(rr, req)
+		if rr.Body.String() != tenant {
+			t.Errorf("Case %v: unexpected response: got %v want %v", i, rr.Body.String(), tenant)
+		}
+	}
+	if c := atomic.LoadInt32(&calls); c != 2 {
+		t.Errorf("Unexpected MRPC requests count: %v", c)
+	}
+}
+
+func TestCachedRequestCanceled(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("ref", func(w mrpc.TopicWriter, data []byte) {
+		close(started)
+		<-release
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte("ref")})
+		w.Write(msg)
+	})
+
+	pxy, _ := New(":80", service)
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.Handle(Endpoint{Path: "/ref", Method: "GET", Topic: "service.ref", Cache: &CacheConfig{TTL: 60000}})
+
+	// The first caller goes away while the request is in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pxy.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ref", nil).WithContext(ctx))
+		close(done)
+	}()
+	<-started
+	cancel()
+	close(release)
+	<-done
+
+	// The shared request completed and the response is cached
+	rr := httptest.NewRecorder()
+	pxy.router.ServeHTTP(rr, httptest.NewRequest("GET", "/ref", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "ref" || rr.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Unexpected response: %v %v %v", rr.Code, rr.Body.String(), rr.Header().Get("X-Cache"))
+	}
+}
+
+func TestCachedRequestPrivate(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("me", func(w mrpc.TopicWriter, data []byte) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-release
+		}
+
+		req := &mrpcproxy.Request{}
+		json.Unmarshal(data, req)
+		msg, _ := json.Marshal(&mrpcproxy.Response{
+			Code:    200,
+			Msg:     []byte(req.Headers.Get("X-User")),
+			Headers: http.Header{"Cache-Control": {"private"}},
+		})
+		w.Write(msg)
+	})
+
+	pxy, _ := New(":80", service)
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.Handle(Endpoint{Path: "/me", Method: "GET", Topic: "service.me", Cache: &CacheConfig{TTL: 60000}})
+
+	// The waiter makes its own request for the private response
+	bodies := make([]string, 2)
+	var wg sync.WaitGroup
+	for i, user := range []string{"alice", "bob"} {
+		wg.Add(1)
+		go func(i int, user string) {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/me", nil)
+			req.Header.Set("X-User", user)
+			rr := httptest.NewRecorder()
+			pxy.router.ServeHTTP(rr, req)
+			bodies[i] = rr.Body.String()
+		}(i, user)
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if bodies[0] != "alice" || bodies[1] != "bob" {
+		t.Errorf("Unexpected bodies: %v", bodies)
+	}
+	if c := atomic.LoadInt32(&calls); c != 2 {
+		t.Errorf("Unexpected MRPC requests count: %v", c)
+	}
+}
+
+func TestCopyResponse(t *testing.T) {
+	res := &mrpcproxy.Response{Code: 200, Msg: []byte("a"), Headers: http.Header{"X-A": {"a"}}}
+	c := copyResponse(res)
+	c.Msg[0] = 'b'
+	c.Headers.Set("X-A", "b")
+	if string(res.Msg) != "a" || res.Headers.Get("X-A") != "a" {
+		t.Errorf("Cached response changed: %+v", res)
+	}
+}
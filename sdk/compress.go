@@ -0,0 +1,218 @@
+package sdk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultCompressionMinSize = 1024
+	defaultMaxRequestSize     = 10 << 20
+)
+
+// ErrRequestTooLarge is returned when the decoded request body is larger than
+// Compression.MaxRequestSize.
+var ErrRequestTooLarge = errors.New("decoded request body too large")
+
+var defaultCompressedTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// Encoder creates a compressing writer for a content encoding.
+type Encoder func(w io.Writer) (io.WriteCloser, error)
+
+// Compression is the response compression configuration.
+//
+// Encodings lists the supported content encodings in order of preference, by default
+// gzip and deflate (zlib). Other encodings, e.g. br or zstd, can be added with their Encoder.
+// ContentTypes are matched as prefixes of the response Content-Type, responses without
+// Content-Type are compressed. MaxRequestSize limits the decoded request bodies, the
+// larger ones get 413.
+type Compression struct {
+	MinSize        int
+	MaxRequestSize int64
+	ContentTypes   []string
+	Encodings      []string
+	Encoders       map[string]Encoder
+}
+
+// NewCompression returns compression configuration with the default settings.
+func NewCompression() *Compression {
+	return &Compression{
+		MinSize:        defaultCompressionMinSize,
+		MaxRequestSize: defaultMaxRequestSize,
+		ContentTypes:   defaultCompressedTypes,
+		Encodings:      []string{"gzip", "deflate"},
+		Encoders: map[string]Encoder{
+			"gzip": func(w io.Writer) (io.WriteCloser, error) {
+				return gzip.NewWriter(w), nil
+			},
+			"deflate": func(w io.Writer) (io.WriteCloser, error) {
+				return zlib.NewWriter(w), nil
+			},
+		},
+	}
+}
+
+// compress compresses the message with the encoding negotiated with Accept-Encoding
+// and sets the response headers. The message is returned unchanged if it shouldn't be
+// compressed.
+func (c *Compression) compress(w http.ResponseWriter, r *http.Request, msg []byte) ([]byte, error) {
+	encoding, ok := c.encoding(w, r, msg)
+	if !ok {
+		return msg, nil
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	if encoding == "" {
+		return msg, nil
+	}
+
+	var buf bytes.Buffer
+	enc, err := c.Encoders[encoding](&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := enc.Write(msg); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Encoding", encoding)
+	if tag := w.Header().Get("ETag"); tag != "" {
+		w.Header().Set("ETag", encodedETag(tag, encoding))
+	}
+	return buf.Bytes(), nil
+}
+
+// encoding returns the encoding negotiated for the message, ok is false when the message
+// isn't compressed regardless of Accept-Encoding.
+func (c *Compression) encoding(w http.ResponseWriter, r *http.Request, msg []byte) (encoding string, ok bool) {
+	if len(msg) < c.MinSize || w.Header().Get("Content-Encoding") != "" {
+		return "", false
+	}
+	if !c.compressible(w.Header().Get("Content-Type")) {
+		return "", false
+	}
+
+	return c.negotiate(r.Header.Get("Accept-Encoding")), true
+}
+
+func (c *Compression) compressible(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	for _, t := range c.ContentTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate returns the supported encoding with the highest quality in Accept-Encoding.
+// Encodings with the same quality are chosen in the Encodings order.
+func (c *Compression) negotiate(accept string) string {
+	if accept == "" {
+		return ""
+	}
+
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		qualities[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, e := range c.Encodings {
+		if _, ok := c.Encoders[e]; !ok {
+			continue
+		}
+		q, ok := qualities[e]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+
+	return best
+}
+
+// decompressRequest replaces gzip or deflate encoded request body with the decoded one.
+// It returns ErrRequestTooLarge when the decoded body is larger than MaxRequestSize.
+func (c *Compression) decompressRequest(r *http.Request) error {
+	if r.Body == nil {
+		return nil
+	}
+
+	var body io.ReadCloser
+	switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+	case "gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return err
+		}
+		body = zr
+	case "deflate":
+		// HTTP deflate is the zlib format, not raw DEFLATE
+		zr, err := zlib.NewReader(r.Body)
+		if err != nil {
+			return err
+		}
+		body = zr
+	default:
+		return nil
+	}
+
+	max := c.MaxRequestSize
+	if max <= 0 {
+		max = defaultMaxRequestSize
+	}
+	msg, err := ioutil.ReadAll(io.LimitReader(body, max+1))
+	body.Close()
+	if err != nil {
+		return err
+	}
+	if int64(len(msg)) > max {
+		return ErrRequestTooLarge
+	}
+	r.Body.Close()
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(msg))
+	r.ContentLength = int64(len(msg))
+	r.Header.Del("Content-Encoding")
+	return nil
+}
+
+// decompressStatus is the response status of the decompressRequest error.
+func decompressStatus(err error) int {
+	if err == ErrRequestTooLarge {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,160 @@
+package sdk
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+func TestCompressionNegotiate(t *testing.T) {
+	c := NewCompression()
+	c.Encodings = []string{"br", "gzip", "deflate"}
+	c.Encoders["br"] = func(w io.Writer) (io.WriteCloser, error) { return nil, nil }
+
+	cases := []struct {
+		accept   string
+		encoding string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"br, gzip", "br"},
+		{"*", "br"},
+		{"gzip;q=0, *;q=0.1", "br"},
+		{"identity", ""},
+		{"gzip;q=0", ""},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			if e := c.negotiate(tc.accept); e != tc.encoding {
+				t.Errorf("Unexpected encoding for %q: got %v want %v", tc.accept, e, tc.encoding)
+			}
+		})
+	}
+}
+
+func TestCompressionHandler(t *testing.T) {
+	large := strings.Repeat("a", defaultCompressionMinSize)
+
+	service, _ := mrpc.NewService(mem.New())
+	// Echo the request message with the requested content type
+	service.HandleFunc("echo", func(w mrpc.TopicWriter, data []byte) {
+		req := &mrpcproxy.Request{}
+		json.Unmarshal(data, req)
+		msg, _ := json.Marshal(&mrpcproxy.Response{
+			Code:    200,
+			Msg:     req.Msg,
+			Headers: http.Header{"Content-Type": {req.Params.Get("type")}},
+		})
+		w.Write(msg)
+	})
+
+	cases := []struct {
+		noCompression bool
+		contentType   string
+		accept        string
+		reqEncoding   string
+		reqBody       string
+		status        int
+		encoding      string
+	}{
+		{contentType: "application/json", accept: "gzip", reqBody: large, status: 200, encoding: "gzip"},
+		{contentType: "text/plain", accept: "deflate", reqBody: large, status: 200, encoding: "deflate"},
+		{contentType: "image/png", accept: "gzip", reqBody: large, status: 200},
+		{contentType: "text/plain", accept: "gzip", reqBody: "small", status: 200},
+		{contentType: "text/plain", reqBody: large, status: 200},
+		{noCompression: true, contentType: "text/plain", accept: "gzip", reqBody: large, status: 200},
+		{contentType: "text/plain", reqEncoding: "gzip", reqBody: large, status: 200},
+		{contentType: "text/plain", accept: "gzip", reqEncoding: "deflate", reqBody: large, status: 200, encoding: "gzip"},
+		{contentType: "text/plain", reqEncoding: "gzip-broken", reqBody: large, status: 400},
+		{contentType: "text/plain", reqEncoding: "deflate-raw", reqBody: large, status: 400},
+		{contentType: "text/plain", reqEncoding: "gzip", reqBody: large + "a", status: 413},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			pxy, _ := New(":80", service)
+			pxy.Compression = NewCompression()
+			pxy.Compression.MaxRequestSize = int64(len(large))
+			pxy.Logger = &MockLogger{}
+			pxy.Requests = &MockLogger{}
+			pxy.Debugger = &MockLogger{}
+
+			h, err := pxy.getTopicHandler(Endpoint{Path: "/echo", Method: "POST", Topic: "service.echo", NoCompression: tc.noCompression})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var body bytes.Buffer
+			switch tc.reqEncoding {
+			case "gzip":
+				zw := gzip.NewWriter(&body)
+				zw.Write([]byte(tc.reqBody))
+				zw.Close()
+			case "deflate":
+				zw := zlib.NewWriter(&body)
+				zw.Write([]byte(tc.reqBody))
+				zw.Close()
+			case "deflate-raw":
+				tc.reqEncoding = "deflate"
+				zw, _ := flate.NewWriter(&body, flate.DefaultCompression)
+				zw.Write([]byte(tc.reqBody))
+				zw.Close()
+			case "gzip-broken":
+				tc.reqEncoding = "gzip"
+				body.WriteString(tc.reqBody)
+			default:
+				body.WriteString(tc.reqBody)
+			}
+
+			req := httptest.NewRequest("POST", "/echo?type="+tc.contentType, &body)
+			req.Header.Set("Accept-Encoding", tc.accept)
+			if tc.reqEncoding != "" {
+				req.Header.Set("Content-Encoding", tc.reqEncoding)
+			}
+			rr := httptest.NewRecorder()
+			h(rr, req, nil)
+
+			if rr.Code != tc.status {
+				t.Fatalf("Unexpected status: got %v want %v", rr.Code, tc.status)
+			}
+			if tc.status != 200 {
+				return
+			}
+
+			if e := rr.Header().Get("Content-Encoding"); e != tc.encoding {
+				t.Fatalf("Unexpected encoding: got %v want %v", e, tc.encoding)
+			}
+
+			var res io.Reader = rr.Body
+			switch tc.encoding {
+			case "gzip":
+				res, _ = gzip.NewReader(rr.Body)
+			case "deflate":
+				res, _ = zlib.NewReader(rr.Body)
+			}
+			msg, err := ioutil.ReadAll(res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg) != tc.reqBody {
+				t.Errorf("Unexpected body: %v", string(msg))
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ConfigError is returned when the configuration or the mapping file is invalid. Line and Column are
+// set when the position of the error is known.
+type ConfigError struct {
+	File         string
+	Line, Column int
+	err          error
+}
+
+func (e ConfigError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%v:%v:%v: %v", e.File, e.Line, e.Column, e.err)
+	}
+	return fmt.Sprintf("%v: %v", e.File, e.err)
+}
+
+// Config is the proxy configuration file. Endpoints has the endpoints.json format.
+//
+// The references in the string values, e.g. ${VAR}, are resolved with Interpolation.
+type Config struct {
+	Server     ServerConfig     `json:"server"`
+	Transport  TransportConfig  `json:"transport"`
+	Defaults   DefaultsConfig   `json:"defaults"`
+	Middleware MiddlewareConfig `json:"middleware"`
+	Auth       AuthConfig       `json:"auth"`
+	Log        LogConfig        `json:"log"`
+	Endpoints  json.RawMessage  `json:"endpoints"`
+
+	eps            []Endpoint
+	trustedProxies []*net.IPNet
+}
+
+// ServerConfig configures the listeners, TLS, draining, health and admin endpoints.
+type ServerConfig struct {
+	Addr         string       `json:"addr"`
+	Listeners    []Listener   `json:"listeners,omitempty"`
+	CertFile     string       `json:"certFile,omitempty"` // Serve HTTPS with ServeTLS
+	KeyFile      string       `json:"keyFile,omitempty"`
+	TLS          *TLSOptions  `json:"tls,omitempty"`
+	DrainGrace   int          `json:"drainGrace,omitempty"`   // In Millisecond
+	DrainTimeout int          `json:"drainTimeout,omitempty"` // In Millisecond
+	Health       *Health      `json:"health,omitempty"`
+	Admin        *Admin       `json:"admin,omitempty"`
+	OpenAPI      *OpenAPIInfo `json:"openapi,omitempty"`
+	MetricsAddr  string       `json:"metricsAddr,omitempty"` // expvar metrics served by cmd/mrpcproxy
+}
+
+// TransportConfig is the MRPC transport URL and the proxy service name, group and version.
+// The service is created by the caller.
+type TransportConfig struct {
+	URL     string `json:"url"`
+	Name    string `json:"name,omitempty"`
+	Group   string `json:"group,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// DefaultsConfig are the defaults of all the endpoints.
+type DefaultsConfig struct {
+	Timeout          int               `json:"timeout,omitempty"` // In Millisecond
+	Headers          map[string]string `json:"headers,omitempty"`
+	ProtectedHeaders []string          `json:"protectedHeaders,omitempty"`
+	RequestIDs       bool              `json:"requestIDs,omitempty"` // Generate random request IDs
+}
+
+// MiddlewareConfig enables the optional request processing.
+type MiddlewareConfig struct {
+	Compression *CompressionConfig `json:"compression,omitempty"`
+	CacheSize   int                `json:"cacheSize,omitempty"` // Response cache entries
+	ETags       bool               `json:"etags,omitempty"`
+	CORS        *CORS              `json:"cors,omitempty"`
+	Security    *SecurityHeaders   `json:"security,omitempty"`
+	StatusPath  string             `json:"statusPath,omitempty"`  // Async job status route
+	ResultTopic string             `json:"resultTopic,omitempty"` // Async job results topic
+}
+
+// CompressionConfig overrides the NewCompression defaults.
+type CompressionConfig struct {
+	MinSize        int      `json:"minSize,omitempty"`
+	MaxRequestSize int64    `json:"maxRequestSize,omitempty"`
+	ContentTypes   []string `json:"contentTypes,omitempty"`
+}
+
+// AuthConfig configures the caller claims. ClaimHeaders maps the claim names to the
+// request headers set by the authenticating gateway, the headers of the requests not
+// coming from the TrustedProxies IPs or CIDR ranges are removed.
+type AuthConfig struct {
+	ClaimHeaders   map[string]string `json:"claimHeaders,omitempty"`
+	TrustedProxies []string          `json:"trustedProxies,omitempty"`
+}
+
+// ErrUntrustedClaims is returned when the claim headers are configured without the trusted
+// proxies setting them.
+var ErrUntrustedClaims = errors.New("auth.claimHeaders requires auth.trustedProxies")
+
+// LogConfig configures the proxy loggers.
+type LogConfig struct {
+	Debug      *bool `json:"debug,omitempty"`      // Enables or disables the debug log
+	NoRequests bool  `json:"noRequests,omitempty"` // Disables the request log
+}
+
+// LoadConfig reads the JSON or, with the .yaml or .yml extension, the YAML configuration
+// file. The file is validated and the endpoints are parsed, Options returns the functional
+// options configuring the proxy.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	configError := func(offset int64, err error) ConfigError {
+		return newConfigError(path, data, offset, err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		// Decoded as JSON with the errors at the YAML positions
+		var positions []yamlPosition
+		if data, positions, err = yamlToJSON(data); err != nil {
+			return nil, ConfigError{File: path, err: err}
+		}
+		configError = func(offset int64, err error) ConfigError {
+			return newYAMLConfigError(path, positions, offset, err)
+		}
+	}
+
+	cfg := &Config{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, configError(jsonErrorOffset(err, data), err)
+	}
+
+	interpolation := Interpolation{Dir: filepath.Dir(path)}
+	if err := interpolate(reflect.ValueOf(cfg).Elem(), interpolation.expand); err != nil {
+		return nil, ConfigError{File: path, err: err}
+	}
+
+	if len(cfg.Auth.ClaimHeaders) > 0 && len(cfg.Auth.TrustedProxies) == 0 {
+		return nil, ConfigError{File: path, err: ErrUntrustedClaims}
+	}
+	if cfg.trustedProxies, err = ParseTrustedProxies(cfg.Auth.TrustedProxies); err != nil {
+		return nil, ConfigError{File: path, err: err}
+	}
+
+	if cfg.Transport.URL != "" {
+		if _, err := url.Parse(cfg.Transport.URL); err != nil {
+			return nil, ConfigError{File: path, err: err}
+		}
+	}
+
+	if len(cfg.Endpoints) > 0 {
+		cfg.eps, err = parseMapping(cfg.Endpoints, filepath.Dir(path), &interpolation)
+		if cerr, ok := err.(ConfigError); ok {
+			// Error in an included file
+			return nil, cerr
+		}
+		if err != nil {
+			// The position of the endpoints in the file
+			start := int64(bytes.Index(data, cfg.Endpoints))
+			offset := int64(0)
+			if perr, ok := err.(ParseError); ok {
+				err = perr.err
+				if offset = jsonErrorOffset(err, cfg.Endpoints); offset < 0 {
+					offset = 0
+				}
+			}
+			return nil, configError(start+offset, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Eps returns the parsed endpoints of the configuration.
+func (c *Config) Eps() []Endpoint {
+	return c.eps
+}
+
+// Options returns the functional options applying the configuration, the endpoints are
+// added with Handle(cfg.Eps()...).
+func (c *Config) Options() []func(*Proxy) error {
+	return []func(*Proxy) error{c.apply}
+}
+
+func (c *Config) apply(pxy *Proxy) error {
+	pxy.Listeners = c.Server.Listeners
+	pxy.TLS = c.Server.TLS
+	pxy.DrainGrace = time.Duration(c.Server.DrainGrace) * time.Millisecond
+	pxy.Health = c.Server.Health
+	pxy.Admin = c.Server.Admin
+	pxy.OpenAPI = c.Server.OpenAPI
+
+	if c.Defaults.Timeout > 0 {
+		pxy.Timeout = time.Duration(c.Defaults.Timeout) * time.Millisecond
+	}
+	pxy.Headers = c.Defaults.Headers
+	pxy.ProtectedHeaders = c.Defaults.ProtectedHeaders
+	if c.Defaults.RequestIDs {
+		pxy.GetID = newJobID
+	}
+
+	m := c.Middleware
+	if m.Compression != nil {
+		pxy.Compression = NewCompression()
+		if m.Compression.MinSize > 0 {
+			pxy.Compression.MinSize = m.Compression.MinSize
+		}
+		if m.Compression.MaxRequestSize > 0 {
+			pxy.Compression.MaxRequestSize = m.Compression.MaxRequestSize
+		}
+		if len(m.Compression.ContentTypes) > 0 {
+			pxy.Compression.ContentTypes = m.Compression.ContentTypes
+		}
+	}
+	if m.CacheSize > 0 {
+		pxy.Cache = NewLRUCache(m.CacheSize)
+	}
+	pxy.ETags = m.ETags
+	pxy.CORS = m.CORS
+	pxy.Security = m.Security
+	pxy.StatusPath = m.StatusPath
+	pxy.ResultTopic = m.ResultTopic
+
+	if len(c.Auth.ClaimHeaders) > 0 {
+		claimHeaders := c.Auth.ClaimHeaders
+		for _, header := range claimHeaders {
+			pxy.GatewayHeaders = append(pxy.GatewayHeaders, header)
+		}
+		pxy.TrustedProxies = c.trustedProxies
+		pxy.Claims = func(r *http.Request) map[string]interface{} {
+			claims := map[string]interface{}{}
+			for claim, header := range claimHeaders {
+				if v := r.Header.Get(header); v != "" {
+					claims[claim] = v
+				}
+			}
+			return claims
+		}
+	}
+
+	// The loggers set by the caller are kept unless configured
+	if c.Log.Debug != nil {
+		if *c.Log.Debug {
+			pxy.Debugger = defaultDebugger
+		} else {
+			pxy.Debugger = log.New(ioutil.Discard, "", 0)
+		}
+	}
+	if c.Log.NoRequests {
+		pxy.Requests = log.New(ioutil.Discard, "", 0)
+	}
+
+	return nil
+}
+
+// jsonErrorOffset returns the offset of the decoding error in data or -1 if unknown.
+func jsonErrorOffset(err error, data []byte) int64 {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		// The offset is after the invalid character
+		return e.Offset - 1
+	case *json.UnmarshalTypeError:
+		return e.Offset
+	}
+
+	// Unknown fields are reported without the offset
+	if m := unknownField.FindStringSubmatch(err.Error()); m != nil {
+		return int64(bytes.Index(data, []byte(m[1])))
+	}
+	return -1
+}
+
+var unknownField = regexp.MustCompile(`^json: unknown field (".*")$`)
+
+func newConfigError(path string, data []byte, offset int64, err error) ConfigError {
+	if offset < 0 || offset > int64(len(data)) {
+		// Unknown position
+		return ConfigError{File: path, err: err}
+	}
+
+	line, col := 1, 1
+	for _, b := range data[:offset] {
+		if b == '\n' {
+			line++
+			col = 1
+			continue
+		}
+		col++
+	}
+	return ConfigError{File: path, Line: line, Column: col, err: err}
+}
@@ -0,0 +1,249 @@
+package sdk
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+)
+
+const testConfig = `{
+	"server": {
+		"addr": ":8080",
+		"listeners": [{"name": "internal", "network": "unix", "address": "/run/proxy.sock"}],
+		"drainGrace": 500,
+		"admin": {"listener": {"name": "admin", "address": "127.0.0.1:9090"}, "token": "${TEST_ADMIN_TOKEN}"}
+	},
+	"transport": {"url": "mem://", "name": "proxy"},
+	"defaults": {
+		"timeout": 2000,
+		"headers": {"X-Env": "${TEST_ENV}"},
+		"protectedHeaders": ["Set-Cookie"],
+		"requestIDs": true
+	},
+	"middleware": {
+		"compression": {"minSize": 10},
+		"cacheSize": 10,
+		"etags": true,
+		"security": {"hsts": "max-age=60"}
+	},
+	"auth": {"claimHeaders": {"sub": "X-User"}, "trustedProxies": ["192.0.2.0/24"]},
+	"log": {"noRequests": true},
+	"endpoints": {
+		"/a": {"endpoints": [{"method": "GET", "topic": "${TEST_ENV}.a"}]}
+	}
+}`
+
+const testYAMLConfig = `
+server:
+  addr: ":8080"
+  drainGrace: 500
+defaults:
+  timeout: 2000
+  headers: {X-Env: "${TEST_ENV}"}
+middleware:
+  etags: true
+log:
+  debug: false
+endpoints:
+  /a:
+    endpoints:
+      - method: GET
+        topic: "${TEST_ENV}.a"
+        keepAlive: 100
+`
+
+func writeConfig(t *testing.T, content string) string {
+	return writeConfigFile(t, "config*.json", content)
+}
+
+func writeConfigFile(t *testing.T, pattern, content string) string {
+	f, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestLoadConfig(t *testing.T) {
+	os.Setenv("TEST_ENV", "staging")
+	os.Setenv("TEST_ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("TEST_ENV")
+	defer os.Unsetenv("TEST_ADMIN_TOKEN")
+
+	path := writeConfig(t, testConfig)
+	defer os.Remove(path)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Transport.URL != "mem://" || cfg.Server.Admin.Token != "secret" {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+
+	// The debug logger isn't configured
+	debugger := &MockLogger{}
+	options := append([]func(*Proxy) error{func(pxy *Proxy) error {
+		pxy.Debugger = debugger
+		return nil
+	}}, cfg.Options()...)
+
+	service, _ := mrpc.NewService(mem.New())
+	pxy, err := New(cfg.Server.Addr, service, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pxy.Eps) != 0 || pxy.Debugger != debugger {
+		t.Errorf("Unexpected endpoints or debugger: %+v %v", pxy.Eps, pxy.Debugger)
+	}
+	if err := pxy.Handle(cfg.Eps()...); err != nil {
+		t.Fatal(err)
+	}
+
+	if pxy.Timeout != 2*time.Second || pxy.DrainGrace != 500*time.Millisecond {
+		t.Errorf("Unexpected timeouts: %v %v", pxy.Timeout, pxy.DrainGrace)
+	}
+	if !reflect.DeepEqual(pxy.Headers, map[string]string{"X-Env": "staging"}) {
+		t.Errorf("Unexpected headers: %v", pxy.Headers)
+	}
+	if len(pxy.Listeners) != 1 || pxy.Listeners[0].Network != "unix" {
+		t.Errorf("Unexpected listeners: %+v", pxy.Listeners)
+	}
+	if pxy.Compression == nil || pxy.Compression.MinSize != 10 || pxy.Cache == nil || !pxy.ETags {
+		t.Errorf("Unexpected middleware: %+v %+v %v", pxy.Compression, pxy.Cache, pxy.ETags)
+	}
+	if pxy.Security.HSTS != "max-age=60" || pxy.Admin.Listener.Address != "127.0.0.1:9090" {
+		t.Errorf("Unexpected security or admin: %+v %+v", pxy.Security, pxy.Admin)
+	}
+	if pxy.GetID() == "" {
+		t.Error("Request IDs not generated")
+	}
+	if len(pxy.Eps) != 1 || pxy.Eps[0].Topic != "staging.a" {
+		t.Errorf("Unexpected endpoints: %+v", pxy.Eps)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-User", "alice")
+	if claims := pxy.Claims(r); claims["sub"] != "alice" {
+		t.Errorf("Unexpected claims: %v", claims)
+	}
+
+	// The claim headers of the untrusted clients are removed
+	r.RemoteAddr = "198.51.100.7:1234"
+	pxy.removeGatewayHeaders(r)
+	if claims := pxy.Claims(r); len(claims) != 0 {
+		t.Errorf("Untrusted claims: %v", claims)
+	}
+}
+
+func TestLoadYAMLConfig(t *testing.T) {
+	os.Setenv("TEST_ENV", "staging")
+	defer os.Unsetenv("TEST_ENV")
+
+	path := writeConfigFile(t, "config*.yaml", testYAMLConfig)
+	defer os.Remove(path)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Addr != ":8080" || cfg.Server.DrainGrace != 500 || !cfg.Middleware.ETags {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+	if cfg.Log.Debug == nil || *cfg.Log.Debug {
+		t.Errorf("Unexpected log config: %+v", cfg.Log)
+	}
+	if !reflect.DeepEqual(cfg.Defaults.Headers, map[string]string{"X-Env": "staging"}) {
+		t.Errorf("Unexpected headers: %v", cfg.Defaults.Headers)
+	}
+	if eps := cfg.Eps(); len(eps) != 1 || eps[0].Topic != "staging.a" || eps[0].KeepAlive != 100 {
+		t.Errorf("Unexpected endpoints: %+v", eps)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	cases := []struct {
+		content string
+		yaml    bool
+		err     string
+	}{
+		{
+			content: "{\n\t\"server\": {\n\t\t\"addr\": 8080\n\t}\n}",
+			err:     ":3:15: json: cannot unmarshal number",
+		},
+		{
+			content: "{\n\t\"server\": {\"addr\": \":80\",}\n}",
+			err:     ":2:27: invalid character '}'",
+		},
+		{
+			content: "{\n\t\"servr\": {}\n}",
+			err:     `:2:2: json: unknown field "servr"`,
+		},
+		{
+			content: "{\n\t\"endpoints\": {\n\t\t\"/a\": {\"endpoints\": [{\"method\": 1}]}\n\t}\n}",
+			err:     ":3:36: json: cannot unmarshal number",
+		},
+		{
+			content: "{\n\t\"endpoints\": {}\n}",
+			err:     ": no paths parsed",
+		},
+		{
+			content: `{"auth": {"claimHeaders": {"sub": "X-User"}}}`,
+			err:     ": auth.claimHeaders requires auth.trustedProxies",
+		},
+		{
+			content: `{"auth": {"claimHeaders": {"sub": "X-User"}, "trustedProxies": ["gateway"]}}`,
+			err:     ": invalid trusted proxy gateway",
+		},
+		{
+			content: "server:\n  addr: 8080\n",
+			yaml:    true,
+			err:     ":2:9: json: cannot unmarshal number",
+		},
+		{
+			content: "server:\n  addr: \":80\"\nservr: {}\n",
+			yaml:    true,
+			err:     `:3:1: json: unknown field "servr"`,
+		},
+		{
+			content: "endpoints:\n  /a:\n    endpoints:\n      - method: GET\n        keepAlive: fast\n",
+			yaml:    true,
+			err:     ":5:20: json: cannot unmarshal string",
+		},
+		{
+			content: "server:\n  addr: [\n",
+			yaml:    true,
+			err:     ": yaml: line 2: did not find expected node content",
+		},
+	}
+
+	for _, tc := range cases {
+		pattern := "config*.json"
+		if tc.yaml {
+			pattern = "config*.yml"
+		}
+		path := writeConfigFile(t, pattern, tc.content)
+		defer os.Remove(path)
+
+		_, err := LoadConfig(path)
+		if err == nil || !strings.HasPrefix(err.Error(), path) || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Unexpected error: got %v want %v", err, tc.err)
+		}
+	}
+
+	if _, err := LoadConfig(filepath.Join(os.TempDir(), "missing-config.json")); !os.IsNotExist(err) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
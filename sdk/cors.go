@@ -0,0 +1,255 @@
+package sdk
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// CORS-safelisted request headers allowed when CORS.AllowedHeaders is empty.
+var defaultCORSHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type"}
+
+// ErrCORSCredentials is returned when the credentials are allowed for any origin. The
+// origin would be echoed back, so any site could make credentialed requests.
+var ErrCORSCredentials = errors.New(`CORS allowCredentials can't be used with the "*" origin`)
+
+// CORS is the Cross-Origin Resource Sharing configuration.
+//
+// AllowedOrigins are exact origins, "*" for any origin or origins with wildcard
+// subdomain, e.g. "https://*.example.com". AllowedOriginPatterns are regular expressions
+// matched against the whole origin. AllowedHeaders can be "*" to allow any request header.
+type CORS struct {
+	AllowedOrigins        []string `json:"allowedOrigins,omitempty"`
+	AllowedOriginPatterns []string `json:"allowedOriginPatterns,omitempty"`
+	AllowedHeaders        []string `json:"allowedHeaders,omitempty"`
+	ExposedHeaders        []string `json:"exposedHeaders,omitempty"`
+	AllowCredentials      bool     `json:"allowCredentials,omitempty"`
+	MaxAge                int      `json:"maxAge,omitempty"` // In seconds
+}
+
+// corsPolicy is the compiled CORS configuration.
+type corsPolicy struct {
+	*CORS
+	anyOrigin bool
+	anyHeader bool
+	origins   map[string]bool
+	wildcards [][2]string // Scheme and domain suffix
+	patterns  []*regexp.Regexp
+	headers   []string
+	headerSet map[string]bool
+}
+
+func newCORSPolicy(c *CORS) (*corsPolicy, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	policy := &corsPolicy{
+		CORS:      c,
+		origins:   map[string]bool{},
+		headers:   c.AllowedHeaders,
+		headerSet: map[string]bool{},
+	}
+
+	for _, o := range c.AllowedOrigins {
+		switch {
+		case o == "*":
+			if c.AllowCredentials {
+				return nil, ErrCORSCredentials
+			}
+			policy.anyOrigin = true
+		case strings.Contains(o, "://*."):
+			parts := strings.SplitN(o, "://*", 2)
+			policy.wildcards = append(policy.wildcards, [2]string{parts[0] + "://", strings.ToLower(parts[1])})
+		default:
+			policy.origins[strings.ToLower(o)] = true
+		}
+	}
+
+	for _, p := range c.AllowedOriginPatterns {
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, err
+		}
+		policy.patterns = append(policy.patterns, re)
+	}
+
+	if len(policy.headers) == 0 {
+		policy.headers = defaultCORSHeaders
+	}
+	for _, h := range policy.headers {
+		if h == "*" {
+			policy.anyHeader = true
+		}
+		policy.headerSet[http.CanonicalHeaderKey(h)] = true
+	}
+
+	return policy, nil
+}
+
+func (c *corsPolicy) allowOrigin(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if c.origins[origin] {
+		return true
+	}
+	for _, w := range c.wildcards {
+		if strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) && len(origin) > len(w[0])+len(w[1]) {
+			return true
+		}
+	}
+	for _, re := range c.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *corsPolicy) allowHeaders(headers string) bool {
+	if c.anyHeader || headers == "" {
+		return true
+	}
+	for _, h := range strings.Split(headers, ",") {
+		if !c.headerSet[http.CanonicalHeaderKey(strings.TrimSpace(h))] {
+			return false
+		}
+	}
+	return true
+}
+
+// setOrigin sets the headers common for actual and preflight requests. It returns
+// false if the origin is not allowed.
+func (c *corsPolicy) setOrigin(w http.ResponseWriter, origin string) bool {
+	if !c.anyOrigin || c.AllowCredentials {
+		w.Header().Add("Vary", "Origin")
+	}
+	if !c.allowOrigin(origin) {
+		return false
+	}
+
+	if c.anyOrigin && !c.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	return true
+}
+
+// withCORS wraps the endpoint handler so the CORS headers are set on responses to
+// cross-origin requests. The endpoint configuration overrides the proxy one.
+func (pxy *Proxy) withCORS(ep Endpoint, h httprouter.Handle) (httprouter.Handle, error) {
+	cfg := ep.CORS
+	if cfg == nil {
+		cfg = pxy.CORS
+	}
+	policy, err := newCORSPolicy(cfg)
+	if err != nil || policy == nil {
+		return h, err
+	}
+
+	if pxy.corsPolicies == nil {
+		pxy.corsPolicies = map[string]*corsPolicy{}
+	}
+	pxy.corsPolicies[ep.Method+" "+ep.Path] = policy
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if policy.setOrigin(w, origin) && len(policy.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+			}
+		}
+		h(w, r, p)
+	}, nil
+}
+
+// optionsHandler handles the CORS preflight requests for the path and falls back to the
+// default options handler for the rest. Methods are the ones allowed for the path.
+func (pxy *Proxy) optionsHandler(path string, methods []string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		origin := r.Header.Get("Origin")
+		method := r.Header.Get("Access-Control-Request-Method")
+		if origin == "" || method == "" || !pxy.hasCORS(path) {
+			pxy.defaultOptionsHandler(w, r, p)
+			return
+		}
+
+		pxy.setHeaders(w)
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		policy := pxy.corsPolicies[method+" "+path]
+		if policy == nil && method == "HEAD" {
+			// GET endpoints respond to HEAD
+			policy = pxy.corsPolicies["GET "+path]
+		}
+		reqHeaders := r.Header.Get("Access-Control-Request-Headers")
+		if policy == nil || !policy.setOrigin(w, origin) || !policy.allowHeaders(reqHeaders) {
+			pxy.Requests.Printf("%v:%v, status: %v, origin: %v", r.Method, r.URL.Path, http.StatusForbidden, origin)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if reqHeaders != "" {
+			if policy.anyHeader {
+				w.Header().Set("Access-Control-Allow-Headers", reqHeaders)
+			} else {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.headers, ", "))
+			}
+		}
+		if policy.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+		}
+
+		// Run custom handler
+		if pxy.Handler != nil {
+			pxy.Handler(w, r, nil)
+		}
+
+		pxy.Requests.Printf("%v:%v, status: %v, origin: %v", r.Method, r.URL.Path, http.StatusNoContent, origin)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (pxy *Proxy) hasCORS(path string) bool {
+	for key := range pxy.corsPolicies {
+		if strings.HasSuffix(key, " "+path) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathMethods returns the methods of the endpoints registered for the path.
+func pathMethods(eps []Endpoint, path string) []string {
+	methods := []string{}
+	registered := map[string]bool{}
+	for _, ep := range eps {
+		if ep.Path != path {
+			continue
+		}
+		registered[ep.Method] = true
+		methods = append(methods, ep.Method)
+	}
+	if registered["GET"] && !registered["HEAD"] {
+		methods = append(methods, "HEAD")
+	}
+	if !registered["OPTIONS"] {
+		methods = append(methods, "OPTIONS")
+	}
+
+	return methods
+}
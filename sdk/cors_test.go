@@ -0,0 +1,222 @@
+package sdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+func TestCORS(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("a", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte("a")})
+		w.Write(msg)
+	})
+
+	pxy, _ := New(":80", service, func(pxy *Proxy) error {
+		pxy.CORS = &CORS{
+			AllowedOrigins:        []string{"https://example.com", "https://*.example.org"},
+			AllowedOriginPatterns: []string{`https://app\d+\.example\.net`},
+			AllowedHeaders:        []string{"Content-Type", "X-Token"},
+			ExposedHeaders:        []string{"X-Request-Id"},
+			MaxAge:                600,
+		}
+		return nil
+	})
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.Handle(
+		Endpoint{Path: "/a", Method: "GET", Topic: "service.a"},
+		Endpoint{Path: "/a", Method: "POST", Topic: "service.a"},
+		Endpoint{Path: "/b", Method: "GET", Topic: "service.a", CORS: &CORS{AllowedOrigins: []string{"*"}}},
+	)
+	pxy.setupRouter()
+
+	cases := []struct {
+		method  string
+		path    string
+		headers map[string]string
+
+		status     int
+		resHeaders http.Header
+	}{
+		{
+			// Same origin request
+			method:     "GET",
+			path:       "/a",
+			status:     200,
+			resHeaders: http.Header{},
+		},
+		{
+			method:  "GET",
+			path:    "/a",
+			headers: map[string]string{"Origin": "https://example.com"},
+			status:  200,
+			resHeaders: http.Header{
+				"Vary":                          {"Origin"},
+				"Access-Control-Allow-Origin":   {"https://example.com"},
+				"Access-Control-Expose-Headers": {"X-Request-Id"},
+			},
+		},
+		{
+			method:  "GET",
+			path:    "/a",
+			headers: map[string]string{"Origin": "https://evil.com"},
+			status:  200,
+			resHeaders: http.Header{
+				"Vary": {"Origin"},
+			},
+		},
+		{
+			method:  "GET",
+			path:    "/b",
+			headers: map[string]string{"Origin": "https://evil.com"},
+			status:  200,
+			resHeaders: http.Header{
+				"Access-Control-Allow-Origin": {"*"},
+			},
+		},
+		{
+			method: "OPTIONS",
+			path:   "/a",
+			headers: map[string]string{
+				"Origin":                         "https://api.example.org",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "x-token",
+			},
+			status: http.StatusNoContent,
+			resHeaders: http.Header{
+				"Vary":                         {"Access-Control-Request-Method", "Access-Control-Request-Headers", "Origin"},
+				"Access-Control-Allow-Origin":  {"https://api.example.org"},
+				"Access-Control-Allow-Methods": {"GET, POST, HEAD, OPTIONS"},
+				"Access-Control-Allow-Headers": {"Content-Type, X-Token"},
+				"Access-Control-Max-Age":       {"600"},
+			},
+		},
+		{
+			method: "OPTIONS",
+			path:   "/a",
+			headers: map[string]string{
+				"Origin":                        "https://app12.example.net",
+				"Access-Control-Request-Method": "GET",
+			},
+			status: http.StatusNoContent,
+			resHeaders: http.Header{
+				"Vary":                         {"Access-Control-Request-Method", "Access-Control-Request-Headers", "Origin"},
+				"Access-Control-Allow-Origin":  {"https://app12.example.net"},
+				"Access-Control-Allow-Methods": {"GET, POST, HEAD, OPTIONS"},
+				"Access-Control-Max-Age":       {"600"},
+			},
+		},
+		{
+			// HEAD uses the GET policy
+			method: "OPTIONS",
+			path:   "/b",
+			headers: map[string]string{
+				"Origin":                        "https://evil.com",
+				"Access-Control-Request-Method": "HEAD",
+			},
+			status: http.StatusNoContent,
+			resHeaders: http.Header{
+				"Vary":                         {"Access-Control-Request-Method", "Access-Control-Request-Headers"},
+				"Access-Control-Allow-Origin":  {"*"},
+				"Access-Control-Allow-Methods": {"GET, HEAD, OPTIONS"},
+			},
+		},
+		{
+			// Origin not allowed
+			method: "OPTIONS",
+			path:   "/a",
+			headers: map[string]string{
+				"Origin":                        "https://example.org",
+				"Access-Control-Request-Method": "GET",
+			},
+			status: http.StatusForbidden,
+			resHeaders: http.Header{
+				"Vary": {"Access-Control-Request-Method", "Access-Control-Request-Headers", "Origin"},
+			},
+		},
+		{
+			// Method not registered
+			method: "OPTIONS",
+			path:   "/a",
+			headers: map[string]string{
+				"Origin":                        "https://example.com",
+				"Access-Control-Request-Method": "DELETE",
+			},
+			status: http.StatusForbidden,
+			resHeaders: http.Header{
+				"Vary": {"Access-Control-Request-Method", "Access-Control-Request-Headers"},
+			},
+		},
+		{
+			// Header not allowed
+			method: "OPTIONS",
+			path:   "/a",
+			headers: map[string]string{
+				"Origin":                         "https://example.com",
+				"Access-Control-Request-Method":  "GET",
+				"Access-Control-Request-Headers": "X-Other",
+			},
+			status: http.StatusForbidden,
+			resHeaders: http.Header{
+				"Vary":                        {"Access-Control-Request-Method", "Access-Control-Request-Headers", "Origin"},
+				"Access-Control-Allow-Origin": {"https://example.com"},
+			},
+		},
+		{
+			// Not a preflight request
+			method:     "OPTIONS",
+			path:       "/a",
+			status:     http.StatusOK,
+			resHeaders: http.Header{},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			for h, v := range tc.headers {
+				req.Header.Set(h, v)
+			}
+			rr := httptest.NewRecorder()
+			pxy.router.ServeHTTP(rr, req)
+
+			if rr.Code != tc.status {
+				t.Errorf("Unexpected status: got %v want %v", rr.Code, tc.status)
+			}
+			if !reflect.DeepEqual(rr.Header(), tc.resHeaders) {
+				t.Errorf("Unexpected headers\nExpected: %v\nReceived: %v", tc.resHeaders, rr.Header())
+			}
+		})
+	}
+}
+
+func TestCORSCredentialsAnyOrigin(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	pxy, _ := New(":80", service)
+	pxy.CORS = &CORS{AllowedOrigins: []string{"https://example.com", "*"}, AllowCredentials: true}
+
+	err := pxy.Handle(Endpoint{Path: "/a", Method: "GET", Topic: "service.a"})
+	if !errors.Is(err, ErrCORSCredentials) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestCORSPatternError(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	pxy, _ := New(":80", service)
+
+	err := pxy.Handle(Endpoint{Path: "/a", Method: "GET", Topic: "service.a", CORS: &CORS{AllowedOriginPatterns: []string{"("}}})
+	if err == nil {
+		t.Error("Expected error")
+	}
+}
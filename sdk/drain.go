@@ -0,0 +1,229 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// How often Stop checks the in-flight requests.
+const drainPollInterval = 10 * time.Millisecond
+
+// DrainError is returned by Stop when the in-flight requests aren't finished before the
+// deadline.
+type DrainError struct {
+	Abandoned map[string]int // In-flight requests by "METHOD path" of the endpoint
+	err       error
+}
+
+func (e DrainError) Error() string {
+	total := 0
+	for _, n := range e.Abandoned {
+		total += n
+	}
+	return fmt.Sprintf("%v in-flight requests abandoned: %v", total, e.err)
+}
+
+// InFlightRequest is a request being processed by the proxy.
+type InFlightRequest struct {
+	Endpoint string    `json:"endpoint"` // "METHOD path" of the endpoint
+	Path     string    `json:"path"`
+	Topic    string    `json:"topic"`
+	Async    bool      `json:"async,omitempty"`
+	Started  time.Time `json:"started"`
+}
+
+// inFlight keeps the requests being processed.
+type inFlight struct {
+	mu       sync.Mutex
+	next     uint64
+	requests map[uint64]*InFlightRequest
+}
+
+type inFlightKey struct{}
+
+func (f *inFlight) start(req *InFlightRequest) uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.requests == nil {
+		f.requests = map[uint64]*InFlightRequest{}
+	}
+	f.next++
+	f.requests[f.next] = req
+	return f.next
+}
+
+func (f *inFlight) done(id uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.requests, id)
+}
+
+// setTopic sets the rendered topic of the tracked HTTP request.
+func (f *inFlight) setTopic(r *http.Request, topic string) {
+	req, ok := r.Context().Value(inFlightKey{}).(*InFlightRequest)
+	if !ok {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	req.Topic = topic
+}
+
+// topic returns the rendered topic of the tracked HTTP request.
+func (f *inFlight) topic(r *http.Request) string {
+	req, ok := r.Context().Value(inFlightKey{}).(*InFlightRequest)
+	if !ok {
+		return ""
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return req.Topic
+}
+
+func (f *inFlight) list() []InFlightRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	requests := make([]InFlightRequest, 0, len(f.requests))
+	for _, req := range f.requests {
+		requests = append(requests, *req)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Started.Before(requests[j].Started) })
+	return requests
+}
+
+func (f *inFlight) counts() map[string]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	counts := map[string]int{}
+	for _, req := range f.requests {
+		counts[req.Endpoint]++
+	}
+	return counts
+}
+
+func endpointKey(ep Endpoint) string {
+	return ep.Method + " " + ep.Path
+}
+
+// InFlight returns the number of requests being processed by "METHOD path" of the
+// endpoint, including the async jobs waiting for the service.
+func (pxy *Proxy) InFlight() map[string]int {
+	return pxy.inFlight.counts()
+}
+
+// Ready reports if the proxy accepts new requests, it's false once Stop is called.
+func (pxy *Proxy) Ready() bool {
+	return atomic.LoadInt32(&pxy.draining) == 0
+}
+
+// track keeps the endpoint requests while they are processed.
+func (pxy *Proxy) track(ep Endpoint, h httprouter.Handle) httprouter.Handle {
+	key := endpointKey(ep)
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		req := &InFlightRequest{Endpoint: key, Path: r.URL.Path, Topic: ep.Topic, Started: time.Now()}
+		id := pxy.inFlight.start(req)
+		defer pxy.inFlight.done(id)
+
+		h(w, r.WithContext(context.WithValue(r.Context(), inFlightKey{}, req)), p)
+	}
+}
+
+// Stop drains and shutdowns the HTTP servers, the admin server is closed last:
+//  1. the proxy is marked not ready
+//  2. DrainGrace is waited for the load balancers to stop sending requests
+//  3. the servers stop accepting connections and wait for the active ones
+//  4. the in-flight requests, including the async jobs, are waited for
+//
+// The context deadline limits the whole sequence, DrainError reports the requests still
+// in-flight when it expires.
+func (pxy *Proxy) Stop(ctx context.Context) error {
+	atomic.StoreInt32(&pxy.draining, 1)
+	defer pxy.closeAdmin()
+
+	if pxy.DrainGrace > 0 {
+		select {
+		case <-time.After(pxy.DrainGrace):
+		case <-ctx.Done():
+		}
+	}
+
+	err := pxy.shutdown(ctx)
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for len(pxy.InFlight()) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if abandoned := pxy.InFlight(); len(abandoned) > 0 {
+				return DrainError{abandoned, ctx.Err()}
+			}
+			return err
+		}
+	}
+
+	return err
+}
+
+func (pxy *Proxy) shutdown(ctx context.Context) error {
+	err := pxy.http.Shutdown(ctx)
+
+	pxy.serversMu.Lock()
+	servers := pxy.servers
+	pxy.serversMu.Unlock()
+	for _, srv := range servers {
+		if srv == pxy.http {
+			continue
+		}
+		if e := srv.Shutdown(ctx); err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// ServeUntilSignal runs serve, e.g. pxy.Serve, until the process receives SIGTERM or
+// SIGINT and stops the proxy within timeout. A second signal abandons the drain.
+func (pxy *Proxy) ServeUntilSignal(serve func() error, timeout time.Duration) error {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+
+	served := make(chan error, 1)
+	go func() { served <- serve() }()
+
+	select {
+	case err := <-served:
+		return err
+	case sig := <-signals:
+		pxy.Logger.Printf("%v received, draining", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return pxy.Stop(ctx)
+}
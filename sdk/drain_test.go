@@ -0,0 +1,144 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+func newDrainProxy(t *testing.T, delay time.Duration) (*Proxy, string) {
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("slow", func(w mrpc.TopicWriter, data []byte) {
+		time.Sleep(delay)
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte("slow")})
+		w.Write(msg)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pxy, _ := New("", service)
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.Listeners = []Listener{{Name: "test", Listener: ln}}
+	pxy.Handle(Endpoint{Path: "/slow", Method: "GET", Topic: "service.slow", KeepAlive: 2000})
+
+	return pxy, "http://" + ln.Addr().String() + "/slow"
+}
+
+func TestStopDrain(t *testing.T) {
+	cases := []struct {
+		delay     time.Duration
+		timeout   time.Duration
+		status    int
+		abandoned map[string]int
+	}{
+		{delay: 200 * time.Millisecond, timeout: time.Second, status: 200},
+		{delay: 500 * time.Millisecond, timeout: 200 * time.Millisecond, abandoned: map[string]int{"GET /slow": 1}},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			pxy, url := newDrainProxy(t, tc.delay)
+			pxy.DrainGrace = 50 * time.Millisecond
+			go pxy.Serve()
+
+			statuses := make(chan int, 1)
+			go func() {
+				res, err := http.Get(url)
+				if err != nil {
+					statuses <- 0
+					return
+				}
+				res.Body.Close()
+				statuses <- res.StatusCode
+			}()
+
+			// Wait for the request to reach the service
+			for len(pxy.InFlight()) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			if !pxy.Ready() {
+				t.Fatal("Proxy not ready before Stop")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+			err := pxy.Stop(ctx)
+
+			if pxy.Ready() {
+				t.Error("Proxy ready after Stop")
+			}
+
+			if tc.abandoned == nil {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if status := <-statuses; status != tc.status {
+					t.Errorf("Unexpected status: got %v want %v", status, tc.status)
+				}
+				return
+			}
+
+			drainErr, ok := err.(DrainError)
+			if !ok {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(drainErr.Abandoned, tc.abandoned) {
+				t.Errorf("Unexpected abandoned: got %v want %v", drainErr.Abandoned, tc.abandoned)
+			}
+		})
+	}
+}
+
+func TestServeUntilSignal(t *testing.T) {
+	pxy, url := newDrainProxy(t, 100*time.Millisecond)
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- pxy.ServeUntilSignal(pxy.Serve, time.Second) }()
+
+	statuses := make(chan int, 1)
+	go func() {
+		for {
+			res, err := http.Get(url)
+			if err != nil {
+				// Not listening yet
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			res.Body.Close()
+			statuses <- res.StatusCode
+			return
+		}
+	}()
+
+	for len(pxy.InFlight()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Proxy not stopped")
+	}
+	if status := <-statuses; status != 200 {
+		t.Errorf("Unexpected status: %v", status)
+	}
+}
@@ -1,7 +1,6 @@
 package sdk
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 )
@@ -18,42 +17,107 @@ func (e ParseError) Error() string {
 var (
 	// ErrNoEndpoints is returned on parsing when endpoints.json is empty
 	ErrNoEndpoints = errors.New("no paths parsed")
+	// ErrBranchName is returned on parsing when aggregate branch names are empty or repeated
+	ErrBranchName = errors.New("aggregate branch names should be unique and not empty")
+	// ErrAggregateOption is returned by Handle for aggregate endpoints with the options
+	// they don't support
+	ErrAggregateOption = errors.New("aggregate endpoints don't support async, cache and the body templates")
 )
 
+// EndpointError is returned by Handle when the endpoint can't be served.
+type EndpointError struct {
+	Method, Path string
+	err          error
+}
+
+func (e EndpointError) Error() string {
+	return fmt.Sprintf("%v %v: %v", e.Method, e.Path, e.err)
+}
+
 // Endpoint is the the representation of a single route.
 type Endpoint struct {
 	Path      string
 	Method    string `json:"method"`
 	Topic     string `json:"topic"`
 	KeepAlive int    `json:"keepAlive"` // In Millisecond. Overrides the default NATS timeout
+	Async     bool   `json:"async"`     // Respond with 202 without waiting for the service
+
+	NoCompression bool         `json:"noCompression,omitempty"` // Disables the response compression
+	Cache         *CacheConfig `json:"cache,omitempty"`         // Enables the response cache of GET endpoint
+
+	// Request headers available in the topic template as {{.Header.Name}}
+	TopicHeaders []string `json:"topicHeaders,omitempty"`
+
+	// Parameter constraints checked before the topic is rendered and the escaping policy
+	// of the values used in the topic: escape (default), reject or raw
+	Params        map[string]ParamConstraint `json:"params,omitempty"`
+	ParamEscaping string                     `json:"paramEscaping,omitempty"`
+
+	// Templates reshaping the request and response bodies, see TransformData
+	RequestTemplate  string `json:"requestTemplate,omitempty"`
+	ResponseTemplate string `json:"responseTemplate,omitempty"`
+
+	// Override the proxy CORS and security headers configuration
+	CORS     *CORS            `json:"cors,omitempty"`
+	Security *SecurityHeaders `json:"security,omitempty"`
+
+	// Aggregate endpoints request all the branch topics in parallel instead of Topic
+	Aggregate []Branch `json:"aggregate,omitempty"`
+
+	// Names of the proxy listeners serving the endpoint, all the listeners by default
+	Listeners []string `json:"listeners,omitempty"`
+
+	// Response headers replacing the Proxy.Headers defaults and the caller requirements
+	Headers map[string]string `json:"headers,omitempty"`
+	Auth    *EndpointAuth     `json:"auth,omitempty"`
+
+	// Documentation of the endpoint in the OpenAPI document, the schemas are $ref values
+	Description    string `json:"description,omitempty"`
+	RequestSchema  string `json:"requestSchema,omitempty"`
+	ResponseSchema string `json:"responseSchema,omitempty"`
+
+	// Schemas validating the request body and query, invalid requests get 400
+	BodySchema  *Schema `json:"bodySchema,omitempty"`
+	QuerySchema *Schema `json:"querySchema,omitempty"`
 }
 
 type endpointsJSON map[string]struct {
 	Endpoints []Endpoint `json:"endpoints"`
 }
 
-// ParseMapping validates and parses endpoints.
+// ParseMapping validates and parses endpoints. The groups and the included files are
+// resolved to the flat endpoints list, the include patterns are relative to the working
+// directory.
 //
 // ParseMapping won't check for duplicated method:path pairs, router.Handle will panic in
 // that case.
 func ParseMapping(eps []byte) ([]Endpoint, error) {
-	topicMap := endpointsJSON{}
-	if err := json.Unmarshal(eps, &topicMap); err != nil {
-		return nil, ParseError{err}
+	return parseMapping(eps, ".", nil)
+}
+
+func parseMapping(eps []byte, dir string, i *Interpolation) ([]Endpoint, error) {
+	l := &mappingLoader{interpolation: i}
+	mapping, err := l.parse(eps, dir, mappingGroup{})
+	if err != nil {
+		return nil, err
 	}
 
-	if len(topicMap) == 0 {
+	if len(mapping) == 0 {
 		// Map is empty
 		return nil, ErrNoEndpoints
 	}
 
-	mapping := []Endpoint{}
-	for path, eps := range topicMap {
-		for _, ep := range eps.Endpoints {
-			ep.Path = path
-			mapping = append(mapping, ep)
+	return mapping, nil
+}
+
+func validateBranches(branches []Branch) error {
+	names := map[string]bool{}
+	for _, b := range branches {
+		if b.Name == "" || names[b.Name] {
+			return ErrBranchName
 		}
+		names[b.Name] = true
 	}
 
-	return mapping, nil
+	return nil
 }
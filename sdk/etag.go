@@ -0,0 +1,116 @@
+package sdk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/miracl/mrpcproxy"
+)
+
+// etag returns the ETag set by the service or a strong ETag generated from the message.
+func etag(res *mrpcproxy.Response) string {
+	if tag := res.Headers.Get("ETag"); tag != "" {
+		return tag
+	}
+
+	sum := sha256.Sum256(res.Msg)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified checks If-None-Match of GET and HEAD requests with weak comparison.
+// Compressed responses have the encoding appended to the ETag, so the suffixes of the
+// known encodings are ignored.
+func (pxy *Proxy) notModified(r *http.Request, tag string) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, t := range parseETags(r.Header.Get("If-None-Match")) {
+		if t == "*" {
+			return true
+		}
+		t = strings.TrimPrefix(t, "W/")
+		if t == tag || pxy.trimEncoding(t) == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (pxy *Proxy) trimEncoding(tag string) string {
+	if pxy.Compression == nil {
+		return tag
+	}
+	for _, e := range pxy.Compression.Encodings {
+		if strings.HasSuffix(tag, `-`+e+`"`) {
+			return strings.TrimSuffix(tag, `-`+e+`"`) + `"`
+		}
+	}
+	return tag
+}
+
+// setNotModifiedETag sets the ETag and Vary of the 304 response the way compress sets
+// them on the 200 response, so the caches see the same validator.
+func (pxy *Proxy) setNotModifiedETag(w http.ResponseWriter, r *http.Request, ep Endpoint, msg []byte) {
+	if pxy.Compression == nil || ep.NoCompression {
+		return
+	}
+
+	encoding, ok := pxy.Compression.encoding(w, r, msg)
+	if !ok {
+		return
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if encoding != "" {
+		w.Header().Set("ETag", encodedETag(w.Header().Get("ETag"), encoding))
+	}
+}
+
+// encodedETag appends the content encoding to a strong ETag so the compressed and
+// uncompressed representations have different tags.
+func encodedETag(tag, encoding string) string {
+	if strings.HasPrefix(tag, "W/") || !strings.HasSuffix(tag, `"`) {
+		return tag
+	}
+	return strings.TrimSuffix(tag, `"`) + "-" + encoding + `"`
+}
+
+// newPreconditions returns the preconditions of write requests or nil.
+func newPreconditions(r *http.Request) *mrpcproxy.Preconditions {
+	if r.Method == "GET" || r.Method == "HEAD" {
+		return nil
+	}
+
+	pre := &mrpcproxy.Preconditions{
+		IfMatch:     parseETags(r.Header.Get("If-Match")),
+		IfNoneMatch: parseETags(r.Header.Get("If-None-Match")),
+	}
+	if since := r.Header.Get("If-Unmodified-Since"); since != "" {
+		if t, err := http.ParseTime(since); err == nil {
+			pre.IfUnmodifiedSince = t.Unix()
+		}
+	}
+
+	if pre.IfMatch == nil && pre.IfNoneMatch == nil && pre.IfUnmodifiedSince == 0 {
+		return nil
+	}
+	return pre
+}
+
+func parseETags(header string) []string {
+	if header == "" {
+		return nil
+	}
+
+	tags := []string{}
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,131 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+func TestETagHandler(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("a", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte(strings.Repeat("a", 2048))})
+		w.Write(msg)
+	})
+	service.HandleFunc("tagged", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte("b"), Headers: http.Header{"Etag": {`W/"v1"`}}})
+		w.Write(msg)
+	})
+
+	tag := etag(&mrpcproxy.Response{Msg: []byte(strings.Repeat("a", 2048))})
+
+	cases := []struct {
+		topic       string
+		method      string
+		ifNoneMatch string
+		acceptEnc   string
+		status      int
+		etag        string
+	}{
+		{topic: "a", method: "GET", status: 200, etag: tag},
+		{topic: "a", method: "GET", ifNoneMatch: tag, status: 304, etag: tag},
+		{topic: "a", method: "HEAD", ifNoneMatch: `"other", ` + tag, status: 304, etag: tag},
+		{topic: "a", method: "GET", ifNoneMatch: `"other"`, status: 200, etag: tag},
+		{topic: "a", method: "GET", ifNoneMatch: "*", status: 304, etag: tag},
+		{topic: "a", method: "GET", acceptEnc: "gzip", status: 200, etag: encodedETag(tag, "gzip")},
+		{topic: "a", method: "GET", acceptEnc: "gzip", ifNoneMatch: encodedETag(tag, "gzip"), status: 304, etag: encodedETag(tag, "gzip")},
+		{topic: "a", method: "GET", acceptEnc: "gzip", ifNoneMatch: tag, status: 304, etag: encodedETag(tag, "gzip")},
+		{topic: "tagged", method: "GET", ifNoneMatch: `"v1"`, status: 304, etag: `W/"v1"`},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			pxy, _ := New(":80", service)
+			pxy.ETags = true
+			pxy.Compression = NewCompression()
+			pxy.Logger = &MockLogger{}
+			l := &MockLogger{}
+			pxy.Requests = l
+
+			h, err := pxy.getTopicHandler(Endpoint{Path: "/" + tc.topic, Method: "GET", Topic: "service." + tc.topic})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(tc.method, "/"+tc.topic, nil)
+			req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			req.Header.Set("Accept-Encoding", tc.acceptEnc)
+			rr := httptest.NewRecorder()
+			h(rr, req, nil)
+
+			if rr.Code != tc.status {
+				t.Errorf("Unexpected status: got %v want %v", rr.Code, tc.status)
+			}
+			if e := rr.Header().Get("ETag"); e != tc.etag {
+				t.Errorf("Unexpected ETag: got %v want %v", e, tc.etag)
+			}
+			if tc.status == 304 && rr.Body.Len() != 0 {
+				t.Errorf("Unexpected body: %v", rr.Body.String())
+			}
+			if tc.topic == "a" && rr.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Unexpected Vary: %v", rr.Header()["Vary"])
+			}
+			if !strings.Contains(l.storage[0], fmt.Sprintf("status: %v", tc.status)) {
+				t.Errorf("Unexpected log: %v", l.storage)
+			}
+		})
+	}
+}
+
+func TestNewPreconditions(t *testing.T) {
+	cases := []struct {
+		method  string
+		headers map[string]string
+		pre     *mrpcproxy.Preconditions
+	}{
+		{
+			method:  "GET",
+			headers: map[string]string{"If-Match": `"a"`},
+		},
+		{
+			method: "PUT",
+		},
+		{
+			method: "PUT",
+			headers: map[string]string{
+				"If-Match":            `"a", "b"`,
+				"If-Unmodified-Since": "Wed, 21 Oct 2015 07:28:00 GMT",
+			},
+			pre: &mrpcproxy.Preconditions{
+				IfMatch:           []string{`"a"`, `"b"`},
+				IfUnmodifiedSince: 1445412480,
+			},
+		},
+		{
+			method:  "POST",
+			headers: map[string]string{"If-None-Match": "*"},
+			pre:     &mrpcproxy.Preconditions{IfNoneMatch: []string{"*"}},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/", nil)
+			for h, v := range tc.headers {
+				r.Header.Set(h, v)
+			}
+
+			if pre := newPreconditions(r); !reflect.DeepEqual(pre, tc.pre) {
+				t.Errorf("Unexpected preconditions: got %+v want %+v", pre, tc.pre)
+			}
+		})
+	}
+}
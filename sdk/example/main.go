@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"flag"
 	"log"
+	"time"
 
 	"github.com/miracl/mrpc"
 	"github.com/miracl/mrpc/transport/mem"
@@ -20,6 +21,8 @@ const (
 	name    = "example"
 	version = "1.0"
 
+	drainTimeout = 30 * time.Second
+
 	endpoints = `
 		{
 			"/hello": {
@@ -86,5 +89,8 @@ func main() {
 	})
 
 	log.Println("Starting example proxy")
-	log.Fatalf("Service stopped: %v", pxy.Serve())
+	if err := pxy.ServeUntilSignal(pxy.Serve, drainTimeout); err != nil {
+		log.Fatalf("Service stopped: %v", err)
+	}
+	log.Println("Service stopped")
 }
@@ -0,0 +1,57 @@
+package sdk
+
+import (
+	"net/http"
+)
+
+// Headers set by the proxy itself that are never copied from the service response.
+var hopHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Keep-Alive":        true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// setServiceHeaders copies the service response headers to the response.
+//
+// The headers are applied in order of precedence:
+//  1. Proxy.Headers defaults, set before the service headers
+//  2. the service headers replace the defaults with the same name, all values are kept
+//  3. Proxy.Handler runs last and can change any header
+//
+// Vary values are appended to the ones set by the proxy. Proxy.ProtectedHeaders and the
+// Proxy.Security headers can't be set by the services.
+func (pxy *Proxy) setServiceHeaders(w http.ResponseWriter, headers http.Header) {
+	for header, values := range headers {
+		header = http.CanonicalHeaderKey(header)
+		if hopHeaders[header] || pxy.protectedHeader(header) || pxy.securityHeader(header) {
+			continue
+		}
+
+		if header != "Vary" {
+			w.Header().Del(header)
+		}
+		for _, v := range values {
+			w.Header().Add(header, v)
+		}
+	}
+}
+
+// setEndpointHeaders sets the Endpoint.Headers, replacing the Proxy.Headers defaults.
+// The service headers replace them the same way.
+func setEndpointHeaders(w http.ResponseWriter, ep Endpoint) {
+	for header, value := range ep.Headers {
+		w.Header().Set(header, value)
+	}
+}
+
+func (pxy *Proxy) protectedHeader(header string) bool {
+	for _, h := range pxy.ProtectedHeaders {
+		if http.CanonicalHeaderKey(h) == header {
+			return true
+		}
+	}
+	return false
+}
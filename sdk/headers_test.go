@@ -0,0 +1,79 @@
+package sdk
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+)
+
+func TestSetServiceHeaders(t *testing.T) {
+	cases := []struct {
+		defaults  map[string]string
+		protected []string
+		existing  http.Header
+		service   http.Header
+		result    http.Header
+	}{
+		{
+			service: http.Header{
+				"Set-Cookie": {"a=1", "b=2"},
+				"Link":       {"</a>; rel=next", "</b>; rel=prev"},
+			},
+			result: http.Header{
+				"Set-Cookie": {"a=1", "b=2"},
+				"Link":       {"</a>; rel=next", "</b>; rel=prev"},
+			},
+		},
+		{
+			defaults: map[string]string{"Content-Type": "text/plain", "X-Default": "a"},
+			service:  http.Header{"content-type": {"application/json"}},
+			result: http.Header{
+				"Content-Type": {"application/json"},
+				"X-Default":    {"a"},
+			},
+		},
+		{
+			defaults:  map[string]string{"X-Frame-Options": "DENY"},
+			protected: []string{"x-frame-options"},
+			service:   http.Header{"X-Frame-Options": {"ALLOWALL"}, "X-Other": {"b"}},
+			result: http.Header{
+				"X-Frame-Options": {"DENY"},
+				"X-Other":         {"b"},
+			},
+		},
+		{
+			existing: http.Header{"Vary": {"Origin"}},
+			service: http.Header{
+				"Vary":              {"Accept-Language"},
+				"Content-Length":    {"10"},
+				"Transfer-Encoding": {"chunked"},
+			},
+			result: http.Header{"Vary": {"Origin", "Accept-Language"}},
+		},
+	}
+
+	service, _ := mrpc.NewService(mem.New())
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			pxy, _ := New(":80", service)
+			pxy.Headers = tc.defaults
+			pxy.ProtectedHeaders = tc.protected
+
+			rr := httptest.NewRecorder()
+			for h, vs := range tc.existing {
+				rr.Header()[h] = vs
+			}
+			pxy.setHeaders(rr)
+			pxy.setServiceHeaders(rr, tc.service)
+
+			if !reflect.DeepEqual(rr.Header(), tc.result) {
+				t.Errorf("Unexpected headers\nExpected: %v\nReceived: %v", tc.result, rr.Header())
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/miracl/mrpcproxy"
+)
+
+// Health check statuses.
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+const defaultHealthCacheTTL = 1 * time.Second
+
+// Health configures the /healthz, /readyz and /livez endpoints.
+//
+// /livez responds 200 while the proxy is serving. /readyz responds 503 when the proxy is
+// draining or any check fails, /healthz when any check fails. Both run the Transport
+// check and the deep Checks and respond with HealthReport. The check results are reused
+// for CacheTTL so frequent probes don't load the services. Listeners are the listeners
+// serving the endpoints, all the listeners by default.
+type Health struct {
+	Transport func() error  `json:"-"` // Checks the MRPC transport, e.g. the NATS connection
+	Checks    []HealthCheck `json:"checks,omitempty"`
+	CacheTTL  int           `json:"cacheTTL,omitempty"` // In Millisecond. Defaults to 1s
+	Listeners []string      `json:"listeners,omitempty"`
+}
+
+// HealthCheck requests the topic with the Probe request. The check fails when the service
+// doesn't respond in time or responds with 5xx code.
+type HealthCheck struct {
+	Name    string             `json:"name"`
+	Topic   string             `json:"topic"`
+	Probe   *mrpcproxy.Request `json:"probe,omitempty"`   // Defaults to request with the probe action
+	Timeout int                `json:"timeout,omitempty"` // In Millisecond. Defaults to the proxy timeout
+}
+
+// HealthReport is the response of the /healthz and /readyz endpoints.
+type HealthReport struct {
+	Status    string                 `json:"status"`
+	Ready     bool                   `json:"ready"`
+	Transport *CheckResult           `json:"transport,omitempty"`
+	Endpoints int                    `json:"endpoints"`
+	Checks    map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the result of a single health check.
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"duration"` // In Millisecond
+}
+
+// HealthCheckError is the error of the health check when the service responds with 5xx.
+type HealthCheckError struct {
+	Code int
+}
+
+func (e HealthCheckError) Error() string {
+	return fmt.Sprintf("service responded with %v", e.Code)
+}
+
+// checkedHealth is the last result of the transport and deep checks.
+type checkedHealth struct {
+	mu      sync.Mutex
+	report  HealthReport
+	expires time.Time
+}
+
+// handleHealth registers the health endpoints.
+func (pxy *Proxy) handleHealth() error {
+	listeners := pxy.Health.Listeners
+	if err := pxy.handle("GET", "/livez", listeners, pxy.livenessHandler); err != nil {
+		return err
+	}
+	if err := pxy.handle("GET", "/readyz", listeners, pxy.healthHandler(true)); err != nil {
+		return err
+	}
+	return pxy.handle("GET", "/healthz", listeners, pxy.healthHandler(false))
+}
+
+// Probes aren't logged to keep the request log readable.
+func (pxy *Proxy) livenessHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	writeHealth(w, http.StatusOK, map[string]string{"status": HealthOK})
+}
+
+func (pxy *Proxy) healthHandler(readiness bool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		report := pxy.healthReport()
+
+		status := http.StatusOK
+		if report.Status != HealthOK || (readiness && !report.Ready) {
+			status = http.StatusServiceUnavailable
+		}
+		writeHealth(w, status, report)
+	}
+}
+
+// healthReport returns the report with the cached check results. The concurrent probes
+// wait for the same checks.
+func (pxy *Proxy) healthReport() *HealthReport {
+	pxy.checked.mu.Lock()
+	defer pxy.checked.mu.Unlock()
+
+	if time.Now().After(pxy.checked.expires) {
+		// Not canceled with the probe so the cached results are complete
+		pxy.checked.report = *pxy.runChecks(context.Background())
+
+		ttl := defaultHealthCacheTTL
+		if pxy.Health.CacheTTL > 0 {
+			ttl = time.Duration(pxy.Health.CacheTTL) * time.Millisecond
+		}
+		pxy.checked.expires = time.Now().Add(ttl)
+	}
+
+	report := pxy.checked.report
+	report.Ready = pxy.Ready()
+	report.Endpoints = len(pxy.Eps)
+	return &report
+}
+
+// runChecks runs the transport and deep checks in parallel.
+func (pxy *Proxy) runChecks(ctx context.Context) *HealthReport {
+	report := &HealthReport{Status: HealthOK}
+
+	if pxy.Health.Transport != nil {
+		start := time.Now()
+		report.Transport = newCheckResult(pxy.Health.Transport(), start)
+	}
+
+	if len(pxy.Health.Checks) > 0 {
+		report.Checks = map[string]CheckResult{}
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, check := range pxy.Health.Checks {
+			wg.Add(1)
+			go func(check HealthCheck) {
+				defer wg.Done()
+				res := pxy.runCheck(ctx, check)
+
+				mu.Lock()
+				report.Checks[check.Name] = *res
+				mu.Unlock()
+			}(check)
+		}
+		wg.Wait()
+	}
+
+	if report.Transport != nil && report.Transport.Status != HealthOK {
+		report.Status = HealthFail
+	}
+	for _, res := range report.Checks {
+		if res.Status != HealthOK {
+			report.Status = HealthFail
+		}
+	}
+
+	return report
+}
+
+func (pxy *Proxy) runCheck(ctx context.Context, check HealthCheck) *CheckResult {
+	start := time.Now()
+
+	timeout := pxy.Timeout
+	if check.Timeout > 0 {
+		timeout = time.Duration(check.Timeout) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	probe := check.Probe
+	if probe == nil {
+		probe = pxy.newRequest(check.Topic, "probe")
+	}
+	msg, err := json.Marshal(probe)
+	if err != nil {
+		return newCheckResult(err, start)
+	}
+
+	resBytes, err := pxy.MRPCService.Request(ctx, check.Topic, msg)
+	if err != nil {
+		return newCheckResult(err, start)
+	}
+
+	res := &mrpcproxy.Response{}
+	if err := json.Unmarshal(resBytes, res); err != nil {
+		return newCheckResult(ResponseError{err}, start)
+	}
+	if res.Code >= http.StatusInternalServerError {
+		return newCheckResult(HealthCheckError{res.Code}, start)
+	}
+
+	return newCheckResult(nil, start)
+}
+
+func newCheckResult(err error, start time.Time) *CheckResult {
+	res := &CheckResult{
+		Status:   HealthOK,
+		Duration: int64(time.Since(start) / time.Millisecond),
+	}
+	if err != nil {
+		res.Status = HealthFail
+		res.Error = err.Error()
+	}
+	return res
+}
+
+func writeHealth(w http.ResponseWriter, status int, report interface{}) {
+	body, _ := json.Marshal(report)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(body)
+}
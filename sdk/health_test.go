@@ -0,0 +1,191 @@
+package sdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("ok", func(w mrpc.TopicWriter, data []byte) {
+		req := &mrpcproxy.Request{}
+		json.Unmarshal(data, req)
+		code := 200
+		if req.Action != "probe" {
+			code = 400
+		}
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: code})
+		w.Write(msg)
+	})
+	service.HandleFunc("broken", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 503})
+		w.Write(msg)
+	})
+
+	cases := []struct {
+		path      string
+		health    *Health
+		draining  bool
+		status    int
+		report    string
+		transport string
+		checks    map[string]string
+	}{
+		{path: "/livez", health: &Health{}, status: 200, report: HealthOK},
+		{path: "/livez", health: &Health{}, draining: true, status: 200, report: HealthOK},
+		{path: "/readyz", health: &Health{}, status: 200, report: HealthOK},
+		{path: "/readyz", health: &Health{}, draining: true, status: 503, report: HealthOK},
+		{path: "/healthz", health: &Health{}, draining: true, status: 200, report: HealthOK},
+		{
+			path:      "/healthz",
+			health:    &Health{Transport: func() error { return errors.New("disconnected") }},
+			status:    503,
+			report:    HealthFail,
+			transport: HealthFail,
+		},
+		{
+			path:      "/readyz",
+			health:    &Health{Transport: func() error { return nil }, Checks: []HealthCheck{{Name: "ok", Topic: "service.ok"}}},
+			status:    200,
+			report:    HealthOK,
+			transport: HealthOK,
+			checks:    map[string]string{"ok": HealthOK},
+		},
+		{
+			path: "/readyz",
+			health: &Health{Checks: []HealthCheck{
+				{Name: "ok", Topic: "service.ok"},
+				{Name: "broken", Topic: "service.broken"},
+				{Name: "missing", Topic: "service.missing", Timeout: 10},
+			}},
+			status: 503,
+			report: HealthFail,
+			checks: map[string]string{"ok": HealthOK, "broken": HealthFail, "missing": HealthFail},
+		},
+		{
+			// Custom probe request
+			path:   "/healthz",
+			health: &Health{Checks: []HealthCheck{{Name: "ok", Topic: "service.ok", Probe: &mrpcproxy.Request{Action: "ping"}}}},
+			status: 200,
+			report: HealthOK,
+			checks: map[string]string{"ok": HealthOK},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			pxy, _ := New(":80", service)
+			pxy.Health = tc.health
+			pxy.Handle(Endpoint{Path: "/a", Method: "GET", Topic: "service.ok"})
+			pxy.setupRouter()
+			if tc.draining {
+				pxy.draining = 1
+			}
+
+			rr := httptest.NewRecorder()
+			pxy.ServeHTTP(rr, httptest.NewRequest("GET", tc.path, nil))
+
+			if rr.Code != tc.status {
+				t.Errorf("Unexpected status: got %v want %v", rr.Code, tc.status)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Unexpected Content-Type: %v", ct)
+			}
+
+			report := &HealthReport{}
+			if err := json.Unmarshal(rr.Body.Bytes(), report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tc.report {
+				t.Errorf("Unexpected report status: got %v want %v", report.Status, tc.report)
+			}
+			if tc.path == "/livez" {
+				return
+			}
+
+			if report.Endpoints != 1 {
+				t.Errorf("Unexpected endpoints: %v", report.Endpoints)
+			}
+			if report.Ready == tc.draining {
+				t.Errorf("Unexpected ready: %v", report.Ready)
+			}
+			if tc.transport != "" && (report.Transport == nil || report.Transport.Status != tc.transport) {
+				t.Errorf("Unexpected transport: got %+v want %v", report.Transport, tc.transport)
+			}
+			if len(report.Checks) != len(tc.checks) {
+				t.Errorf("Unexpected checks: %+v", report.Checks)
+			}
+			for name, status := range tc.checks {
+				if report.Checks[name].Status != status {
+					t.Errorf("Unexpected %v check: got %+v want %v", name, report.Checks[name], status)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthCache(t *testing.T) {
+	var calls int32
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("ok", func(w mrpc.TopicWriter, data []byte) {
+		atomic.AddInt32(&calls, 1)
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200})
+		w.Write(msg)
+	})
+
+	pxy, _ := New(":80", service)
+	pxy.Health = &Health{Checks: []HealthCheck{{Name: "ok", Topic: "service.ok"}}, CacheTTL: 60000}
+	if err := pxy.setupRouter(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/readyz", "/healthz", "/readyz"} {
+		rr := httptest.NewRecorder()
+		pxy.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != 200 {
+			t.Errorf("%v: unexpected status: %v", path, rr.Code)
+		}
+	}
+	if c := atomic.LoadInt32(&calls); c != 1 {
+		t.Errorf("Unexpected checks count: %v", c)
+	}
+
+	// The draining is reported without waiting for the cache
+	pxy.draining = 1
+	rr := httptest.NewRecorder()
+	pxy.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != 503 {
+		t.Errorf("Unexpected status: %v", rr.Code)
+	}
+}
+
+func TestSetupRouter(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+
+	pxy, _ := New(":80", service)
+	pxy.Health = &Health{}
+	pxy.Handle(Endpoint{Path: "/a", Method: "GET", Topic: "service.a"})
+	for i := 0; i < 2; i++ {
+		if err := pxy.setupRouter(); err != nil {
+			t.Fatalf("Setup %v: %v", i, err)
+		}
+	}
+
+	// The health endpoints conflict with the wildcard
+	pxy, _ = New(":80", service)
+	pxy.Health = &Health{}
+	pxy.Handle(Endpoint{Path: "/:id", Method: "GET", Topic: "service.a"})
+	err := pxy.setupRouter()
+	if _, ok := err.(RouteError); !ok || !strings.Contains(err.Error(), "invalid route GET /livez") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
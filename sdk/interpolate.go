@@ -0,0 +1,162 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Prefix of the values read from a file.
+const filePrefix = "file://"
+
+// InterpolationError is returned when a reference in the mapping can't be resolved.
+type InterpolationError struct {
+	Value string
+	err   error
+}
+
+func (e InterpolationError) Error() string {
+	return fmt.Sprintf("can't resolve %q: %v", e.Value, e.err)
+}
+
+// Interpolation resolves the references in the string values:
+//
+//	${VAR}              the environment variable, error if not set
+//	${VAR:-default}     the environment variable or default if not set or empty
+//	$${VAR}             the literal ${VAR}
+//	file://path         the content of the file without the trailing newline
+//
+// The environment variables are replaced before the file is read, so the file path can
+// contain them.
+type Interpolation struct {
+	LookupEnv func(string) (string, bool) // Defaults to os.LookupEnv
+	Dir       string                      // Directory of the relative file paths
+}
+
+var envRef = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+func (i Interpolation) expand(s string) (string, error) {
+	lookup := i.LookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+
+	var err error
+	expanded := envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+
+		m := envRef.FindStringSubmatch(ref)
+		v, ok := lookup(m[1])
+		if m[2] != "" {
+			if v == "" {
+				return m[3]
+			}
+			return v
+		}
+		if !ok && err == nil {
+			err = InterpolationError{s, fmt.Errorf("environment variable %v not set", m[1])}
+		}
+		return v
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(expanded, filePrefix) {
+		path := strings.TrimPrefix(expanded, filePrefix)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(i.Dir, path)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", InterpolationError{s, err}
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	return expanded, nil
+}
+
+// ResolveMapping resolves the references in the string values of the endpoints.
+func ResolveMapping(eps []Endpoint, i Interpolation) error {
+	for j := range eps {
+		if err := interpolate(reflect.ValueOf(&eps[j]).Elem(), i.expand); err != nil {
+			return fmt.Errorf("%v %v: %v", eps[j].Method, eps[j].Path, err)
+		}
+	}
+	return nil
+}
+
+// LoadMapping reads and parses the endpoints file like ParseMapping and resolves the
+// references with Interpolation. The include patterns and the relative file paths are
+// relative to the directory of the file they are in.
+func LoadMapping(path string) ([]Endpoint, error) {
+	l := &mappingLoader{interpolation: &Interpolation{}}
+	eps, err := l.loadFile(path, mappingGroup{})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(eps) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	return eps, nil
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// interpolate replaces the string values of v with the expanded ones.
+func interpolate(v reflect.Value, expand func(string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := expand(v.String())
+		if err != nil {
+			return err
+		}
+		if v.CanSet() {
+			v.SetString(s)
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return interpolate(v.Elem(), expand)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				// Unexported field
+				continue
+			}
+			if err := interpolate(v.Field(i), expand); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if v.Type() == rawMessageType {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := interpolate(v.Index(i), expand); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			if err := interpolate(elem, expand); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+	}
+
+	return nil
+}
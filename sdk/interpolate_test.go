@@ -0,0 +1,111 @@
+package sdk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterpolationExpand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "interpolation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "key"), []byte("s3cret\n"), 0600)
+
+	env := map[string]string{"ENV": "prod", "EMPTY": "", "DIR": dir}
+	i := Interpolation{
+		LookupEnv: func(k string) (string, bool) {
+			v, ok := env[k]
+			return v, ok
+		},
+		Dir: dir,
+	}
+
+	cases := []struct {
+		value    string
+		expanded string
+		err      string
+	}{
+		{value: "users.get", expanded: "users.get"},
+		{value: "${ENV}.users.get", expanded: "prod.users.get"},
+		{value: "${ENV}.{{.id}}", expanded: "prod.{{.id}}"},
+		{value: "${EMPTY}x", expanded: "x"},
+		{value: "${MISSING}.users", err: "environment variable MISSING not set"},
+		{value: "${MISSING:-dev}.users", expanded: "dev.users"},
+		{value: "${EMPTY:-dev}.users", expanded: "dev.users"},
+		{value: "${ENV:-dev}.users", expanded: "prod.users"},
+		{value: "${MISSING:-}", expanded: ""},
+		{value: "$${ENV}", expanded: "${ENV}"},
+		{value: "$ENV", expanded: "$ENV"},
+		{value: "file://key", expanded: "s3cret"},
+		{value: "file://${DIR}/key", expanded: "s3cret"},
+		{value: "file://missing", err: "no such file"},
+		{value: "prefix file://key", expanded: "prefix file://key"},
+	}
+
+	for n, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", n), func(t *testing.T) {
+			expanded, err := i.expand(tc.value)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Unexpected error: got %v want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expanded != tc.expanded {
+				t.Errorf("Unexpected value: got %q want %q", expanded, tc.expanded)
+			}
+		})
+	}
+}
+
+func TestLoadMapping(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mapping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("TEST_TOPIC_PREFIX", "staging")
+	defer os.Unsetenv("TEST_TOPIC_PREFIX")
+
+	ioutil.WriteFile(filepath.Join(dir, "origin"), []byte("https://staging.example.com\n"), 0600)
+	path := filepath.Join(dir, "endpoints.json")
+	ioutil.WriteFile(path, []byte(`{
+		"/users/:id": {"endpoints": [{
+			"method": "GET",
+			"topic": "${TEST_TOPIC_PREFIX}.users.{{.id}}",
+			"cors": {"allowedOrigins": ["file://origin"]},
+			"security": {"csp": "${TEST_CSP:-default-src 'none'}"}
+		}]}
+	}`), 0600)
+
+	eps, err := LoadMapping(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := eps[0]
+	if ep.Topic != "staging.users.{{.id}}" {
+		t.Errorf("Unexpected topic: %v", ep.Topic)
+	}
+	if ep.CORS.AllowedOrigins[0] != "https://staging.example.com" {
+		t.Errorf("Unexpected origins: %v", ep.CORS.AllowedOrigins)
+	}
+	if ep.Security.CSP != "default-src 'none'" {
+		t.Errorf("Unexpected CSP: %v", ep.Security.CSP)
+	}
+
+	ioutil.WriteFile(path, []byte(`{"/a": {"endpoints": [{"method": "GET", "topic": "${TEST_MISSING_PREFIX}.a"}]}}`), 0600)
+	_, err = LoadMapping(path)
+	if err == nil || !strings.Contains(err.Error(), "GET /a: can't resolve") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,313 @@
+package sdk
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// DefaultListener is the name of the listener on the address passed to New.
+const DefaultListener = "default"
+
+// First file descriptor passed by systemd socket activation.
+const listenFDsStart = 3
+
+var (
+	// ErrListenerName is returned when the listener names are empty or repeated
+	ErrListenerName = errors.New("listener names should be unique and not empty")
+	// ErrSystemdSocket is returned when the systemd socket isn't passed to the process
+	ErrSystemdSocket = errors.New("systemd socket not found")
+)
+
+// ListenerError is returned when the proxy can't listen on a listener.
+type ListenerError struct {
+	Name string
+	err  error
+}
+
+func (e ListenerError) Error() string {
+	return fmt.Sprintf("error listening on %v: %v", e.Name, e.err)
+}
+
+// Listener is an additional address the proxy serves on.
+//
+// Network is tcp (default), unix or systemd. The systemd Address is the socket
+// FileDescriptorName, empty for the first passed socket. Listener is used as it is when
+// set. Endpoint.Listeners refers to the listeners by Name.
+type Listener struct {
+	Name     string       `json:"name"`
+	Network  string       `json:"network,omitempty"`
+	Address  string       `json:"address,omitempty"`
+	Listener net.Listener `json:"-"`
+}
+
+// RouteError is returned when the route conflicts with the registered routes.
+type RouteError struct {
+	Method, Path string
+	err          error
+}
+
+func (e RouteError) Error() string {
+	return fmt.Sprintf("invalid route %v %v: %v", e.Method, e.Path, e.err)
+}
+
+type route struct {
+	method, path string
+	handle       httprouter.Handle
+	listeners    []string
+	// Builds the handler of the listener from its endpoints
+	newHandle func(eps []Endpoint) (httprouter.Handle, error)
+}
+
+// handle registers the route and keeps it for the listener routers. The routes limited
+// to other listeners aren't served by the default listener router.
+func (pxy *Proxy) handle(method, path string, listeners []string, h httprouter.Handle) (err error) {
+	// The router panics on conflicting routes
+	defer func() {
+		if r := recover(); r != nil {
+			err = RouteError{method, path, fmt.Errorf("%v", r)}
+		}
+	}()
+
+	pxy.allRoutes.Handle(method, path, h)
+	if servedOn(listeners, DefaultListener) {
+		pxy.router.Handle(method, path, h)
+	}
+	pxy.routes = append(pxy.routes, route{method, path, h, listeners, nil})
+	return nil
+}
+
+// handleEndpoints registers the route served on all the listeners with the handler of
+// the endpoints of each listener.
+func (pxy *Proxy) handleEndpoints(method, path string, newHandle func(eps []Endpoint) (httprouter.Handle, error)) error {
+	h, err := newHandle(pxy.listenerEps(DefaultListener))
+	if err != nil {
+		return err
+	}
+	if err := pxy.handle(method, path, nil, h); err != nil {
+		return err
+	}
+
+	pxy.routes[len(pxy.routes)-1].newHandle = newHandle
+	return nil
+}
+
+// serveListeners serves on all the listeners until one of them fails and waits for all
+// the servers to exit. The servers have the timeouts of the main server.
+func (pxy *Proxy) serveListeners(cfg *tls.Config) error {
+	listeners := pxy.listeners()
+
+	lns := []net.Listener{}
+	servers := []*http.Server{}
+	for _, l := range listeners {
+		ln, err := l.listen()
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return ListenerError{l.Name, err}
+		}
+		lns = append(lns, ln)
+
+		srv := pxy.http
+		if l.Name != DefaultListener {
+			srv = &http.Server{
+				ReadTimeout:       pxy.http.ReadTimeout,
+				ReadHeaderTimeout: pxy.http.ReadHeaderTimeout,
+				WriteTimeout:      pxy.http.WriteTimeout,
+				IdleTimeout:       pxy.http.IdleTimeout,
+				MaxHeaderBytes:    pxy.http.MaxHeaderBytes,
+				ErrorLog:          pxy.http.ErrorLog,
+			}
+		}
+		router, err := pxy.listenerRouter(l.Name)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return err
+		}
+		srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pxy.serveRouter(router, w, r)
+		})
+		srv.TLSConfig = cfg
+		servers = append(servers, srv)
+	}
+
+	pxy.serversMu.Lock()
+	pxy.servers = servers
+	pxy.serversMu.Unlock()
+
+	errs := make(chan error, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server, ln net.Listener) {
+			defer wg.Done()
+			if cfg != nil {
+				errs <- srv.ServeTLS(ln, "", "")
+				return
+			}
+			errs <- srv.Serve(ln)
+		}(srv, lns[i])
+	}
+
+	// Stop shuts down all the servers, the other failures close the rest
+	err := <-errs
+	if err != http.ErrServerClosed {
+		for _, srv := range servers {
+			srv.Close()
+		}
+	}
+	wg.Wait()
+	return err
+}
+
+// listeners returns the served listeners, the default listener is on the address passed
+// to New.
+func (pxy *Proxy) listeners() []Listener {
+	if pxy.http.Addr == "" {
+		return pxy.Listeners
+	}
+	return append([]Listener{{Name: DefaultListener, Address: pxy.http.Addr}}, pxy.Listeners...)
+}
+
+// checkListeners checks the listener names and the listeners used by the routes.
+func (pxy *Proxy) checkListeners(listeners []Listener) error {
+	names := map[string]bool{}
+	for _, l := range listeners {
+		if l.Name == "" || names[l.Name] {
+			return ErrListenerName
+		}
+		names[l.Name] = true
+	}
+
+	for _, rt := range pxy.routes {
+		for _, name := range rt.listeners {
+			if !names[name] {
+				return fmt.Errorf("unknown listener %v for %v:%v", name, rt.method, rt.path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// listenerRouter returns a router with the routes served on the listener.
+func (pxy *Proxy) listenerRouter(name string) (*httprouter.Router, error) {
+	eps := pxy.listenerEps(name)
+	router := httprouter.New()
+	for _, rt := range pxy.routes {
+		if !servedOn(rt.listeners, name) {
+			continue
+		}
+
+		h := rt.handle
+		if rt.newHandle != nil {
+			var err error
+			if h, err = rt.newHandle(eps); err != nil {
+				return nil, err
+			}
+		}
+		router.Handle(rt.method, rt.path, h)
+	}
+	pxy.configureRouter(router, eps)
+
+	return router, nil
+}
+
+// listenerEps returns the endpoints served on the listener.
+func (pxy *Proxy) listenerEps(name string) []Endpoint {
+	eps := []Endpoint{}
+	for _, ep := range pxy.Eps {
+		if servedOn(ep.Listeners, name) {
+			eps = append(eps, ep)
+		}
+	}
+	return eps
+}
+
+func servedOn(listeners []string, name string) bool {
+	if len(listeners) == 0 {
+		return true
+	}
+	for _, l := range listeners {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (l Listener) listen() (net.Listener, error) {
+	if l.Listener != nil {
+		return l.Listener, nil
+	}
+
+	switch l.Network {
+	case "", "tcp":
+		return net.Listen("tcp", l.Address)
+	case "unix":
+		removeStaleSocket(l.Address)
+		return net.Listen("unix", l.Address)
+	case "systemd":
+		return systemdListener(l.Address)
+	default:
+		return nil, fmt.Errorf("unknown network: %v", l.Network)
+	}
+}
+
+// removeStaleSocket removes the socket file left by a previous process.
+func removeStaleSocket(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		// The socket is in use
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+func systemdListener(name string) (net.Listener, error) {
+	fd, err := systemdFD(name, os.Getenv, os.Getpid())
+	if err != nil {
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// systemdFD returns the file descriptor of the named socket passed by systemd.
+func systemdFD(name string, getenv func(string) string, pid int) (int, error) {
+	if getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return 0, ErrSystemdSocket
+	}
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return 0, ErrSystemdSocket
+	}
+	if name == "" {
+		return listenFDsStart, nil
+	}
+
+	for i, fdName := range strings.Split(getenv("LISTEN_FDNAMES"), ":") {
+		if i < n && fdName == name {
+			return listenFDsStart + i, nil
+		}
+	}
+	return 0, ErrSystemdSocket
+}
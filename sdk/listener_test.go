@@ -0,0 +1,272 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+func TestServeListeners(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("a", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte("a")})
+		w.Write(msg)
+	})
+
+	dir, err := ioutil.TempDir("", "listeners")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "proxy.sock")
+
+	public, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	internal, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pxy, _ := New("", service)
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.Listeners = []Listener{
+		{Name: "public", Listener: public},
+		{Name: "internal", Listener: internal},
+		{Name: "local", Network: "unix", Address: socket},
+	}
+	pxy.Handle(
+		Endpoint{Path: "/a", Method: "GET", Topic: "service.a"},
+		Endpoint{Path: "/b", Method: "GET", Topic: "service.a", Listeners: []string{"internal", "local"}},
+		Endpoint{Path: "/c", Method: "GET", Topic: "service.a", Listeners: []string{"public"}},
+		Endpoint{Path: "/c", Method: "POST", Topic: "service.a", Listeners: []string{"internal"}},
+	)
+
+	pxy.http.ReadTimeout = time.Minute
+	pxy.http.IdleTimeout = 2 * time.Minute
+
+	served := make(chan error)
+	go func() { served <- pxy.Serve() }()
+
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	// Wait for the unix socket
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cases := []struct {
+		client *http.Client
+		addr   string
+		method string
+		path   string
+		status int
+		allow  string
+	}{
+		{client: http.DefaultClient, addr: public.Addr().String(), method: "GET", path: "/a", status: 200},
+		{client: http.DefaultClient, addr: internal.Addr().String(), method: "GET", path: "/a", status: 200},
+		{client: unixClient, addr: "unix", method: "GET", path: "/a", status: 200},
+		{client: http.DefaultClient, addr: public.Addr().String(), method: "GET", path: "/b", status: 404},
+		{client: http.DefaultClient, addr: internal.Addr().String(), method: "GET", path: "/b", status: 200},
+		{client: unixClient, addr: "unix", method: "GET", path: "/b", status: 200},
+		{client: http.DefaultClient, addr: public.Addr().String(), method: "POST", path: "/c", status: 405, allow: "GET, HEAD, OPTIONS"},
+		{client: http.DefaultClient, addr: internal.Addr().String(), method: "POST", path: "/c", status: 200},
+		{client: http.DefaultClient, addr: internal.Addr().String(), method: "GET", path: "/c", status: 405, allow: "POST, OPTIONS"},
+		{client: unixClient, addr: "unix", method: "GET", path: "/c", status: 404},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			req, _ := http.NewRequest(tc.method, "http://"+tc.addr+tc.path, nil)
+			res, err := tc.client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != tc.status {
+				t.Errorf("Unexpected status: got %v want %v", res.StatusCode, tc.status)
+			}
+			if allow := res.Header.Get("Allow"); allow != tc.allow {
+				t.Errorf("Unexpected Allow: got %v want %v", allow, tc.allow)
+			}
+		})
+	}
+
+	pxy.serversMu.Lock()
+	for _, srv := range pxy.servers {
+		if srv.ReadTimeout != time.Minute || srv.IdleTimeout != 2*time.Minute {
+			t.Errorf("Unexpected server timeouts: %v %v", srv.ReadTimeout, srv.IdleTimeout)
+		}
+	}
+	pxy.serversMu.Unlock()
+
+	if err := pxy.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != http.ErrServerClosed {
+		t.Errorf("Unexpected serve error: %v", err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("Socket not removed: %v", err)
+	}
+}
+
+func TestServeListenersFailure(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+
+	failing, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pxy, _ := New("", service)
+	pxy.Listeners = []Listener{{Name: "failing", Listener: failing}, {Name: "other", Listener: other}}
+	pxy.Handle(Endpoint{Path: "/a", Method: "GET", Topic: "service.a"})
+
+	served := make(chan error)
+	go func() { served <- pxy.Serve() }()
+
+	// Wait for the servers before one of them fails
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", other.Addr().String()); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	failing.Close()
+
+	if err := <-served; err == nil || err == http.ErrServerClosed {
+		t.Errorf("Unexpected serve error: %v", err)
+	}
+	if conn, err := net.Dial("tcp", other.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("Other listener still serving")
+	}
+}
+
+func TestCheckListeners(t *testing.T) {
+	cases := []struct {
+		listeners []Listener
+		eps       []Endpoint
+		err       bool
+	}{
+		{listeners: []Listener{{Name: "a"}, {Name: "b"}}, eps: []Endpoint{{Path: "/", Method: "GET", Listeners: []string{"b"}}}},
+		{listeners: []Listener{{Name: "a"}, {Name: "a"}}, err: true},
+		{listeners: []Listener{{Name: ""}}, err: true},
+		{listeners: []Listener{{Name: "a"}}, eps: []Endpoint{{Path: "/", Method: "GET", Listeners: []string{"b"}}}, err: true},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			service, _ := mrpc.NewService(mem.New())
+			pxy, _ := New("", service)
+			if err := pxy.Handle(tc.eps...); err != nil {
+				t.Fatal(err)
+			}
+
+			err := pxy.checkListeners(tc.listeners)
+			if (err != nil) != tc.err {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestListenerRoutes(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("secret", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte("secret")})
+		w.Write(msg)
+	})
+
+	pxy, _ := New("127.0.0.1:0", service)
+	pxy.Requests = &MockLogger{}
+	if err := pxy.Handle(Endpoint{Path: "/internal", Method: "GET", Topic: "service.secret", Listeners: []string{"internal"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The internal endpoint isn't served by the default listener
+	if err := pxy.Serve(); err == nil || !strings.Contains(err.Error(), "unknown listener internal") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	pxy.ServeHTTP(rr, httptest.NewRequest("GET", "/internal", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status: %v %v", rr.Code, rr.Body.String())
+	}
+
+	router, err := pxy.listenerRouter("internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/internal", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "secret" {
+		t.Errorf("Unexpected internal response: %v %v", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSystemdFD(t *testing.T) {
+	cases := []struct {
+		name string
+		env  map[string]string
+		fd   int
+		err  error
+	}{
+		{env: map[string]string{}, err: ErrSystemdSocket},
+		{env: map[string]string{"LISTEN_PID": "2", "LISTEN_FDS": "1"}, err: ErrSystemdSocket},
+		{env: map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "0"}, err: ErrSystemdSocket},
+		{env: map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "1"}, fd: 3},
+		{
+			name: "internal",
+			env:  map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "2", "LISTEN_FDNAMES": "public:internal"},
+			fd:   4,
+		},
+		{
+			name: "admin",
+			env:  map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "2", "LISTEN_FDNAMES": "public:internal"},
+			err:  ErrSystemdSocket,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			getenv := func(k string) string { return tc.env[k] }
+			fd, err := systemdFD(tc.name, getenv, 1)
+			if err != tc.err {
+				t.Fatalf("Unexpected error: got %v want %v", err, tc.err)
+			}
+			if fd != tc.fd {
+				t.Errorf("Unexpected fd: got %v want %v", fd, tc.fd)
+			}
+		})
+	}
+}
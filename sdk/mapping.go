@@ -0,0 +1,295 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+)
+
+// Keys of the mapping that are not paths.
+const (
+	includeKey = "include"
+	groupsKey  = "groups"
+)
+
+var (
+	// ErrIncludeCycle is returned when a mapping file includes itself
+	ErrIncludeCycle = errors.New("include cycle")
+	// ErrNoIncludeFiles is returned when an include pattern doesn't match any file
+	ErrNoIncludeFiles = errors.New("no files match the pattern")
+)
+
+// IncludeError is returned when the included mapping files can't be loaded.
+type IncludeError struct {
+	Pattern string
+	err     error
+}
+
+func (e IncludeError) Error() string {
+	return fmt.Sprintf("include %v: %v", e.Pattern, e.err)
+}
+
+// mappingGroup is a set of paths sharing the path prefix, the topic prefix, the timeout,
+// the response headers and the auth requirements. The mapping itself is the root group
+// with the paths as keys.
+//
+// The nested groups and the included files inherit the group values. The endpoints
+// override the timeout and the auth and add to the headers, the topic prefix is added to
+// the endpoint and the aggregate branch topics.
+type mappingGroup struct {
+	Prefix      string            `json:"prefix"`
+	TopicPrefix string            `json:"topicPrefix,omitempty"`
+	Timeout     int               `json:"timeout,omitempty"` // In Millisecond
+	Headers     map[string]string `json:"headers,omitempty"`
+	Auth        *EndpointAuth     `json:"auth,omitempty"`
+	Include     []string          `json:"include,omitempty"` // Glob patterns of the mapping files
+	Groups      []mappingGroup    `json:"groups,omitempty"`
+	Endpoints   endpointsJSON     `json:"endpoints,omitempty"`
+}
+
+// inherit returns the group values merged with the parent ones.
+func (g mappingGroup) inherit(parent mappingGroup) mappingGroup {
+	merged := mappingGroup{
+		Prefix:      parent.Prefix + g.Prefix,
+		TopicPrefix: parent.TopicPrefix + g.TopicPrefix,
+		Timeout:     parent.Timeout,
+		Headers:     mergeHeaders(parent.Headers, g.Headers),
+		Auth:        parent.Auth,
+	}
+	if g.Timeout > 0 {
+		merged.Timeout = g.Timeout
+	}
+	if g.Auth != nil {
+		merged.Auth = g.Auth
+	}
+	return merged
+}
+
+// apply returns the endpoint with the group values.
+func (g mappingGroup) apply(ep Endpoint) Endpoint {
+	ep.Path = g.Prefix + ep.Path
+	if ep.Topic != "" {
+		ep.Topic = g.TopicPrefix + ep.Topic
+	}
+	if g.TopicPrefix != "" && len(ep.Aggregate) > 0 {
+		branches := make([]Branch, len(ep.Aggregate))
+		for i, b := range ep.Aggregate {
+			b.Topic = g.TopicPrefix + b.Topic
+			branches[i] = b
+		}
+		ep.Aggregate = branches
+	}
+	if ep.KeepAlive == 0 {
+		ep.KeepAlive = g.Timeout
+	}
+	ep.Headers = mergeHeaders(g.Headers, ep.Headers)
+	if ep.Auth == nil {
+		ep.Auth = g.Auth
+	}
+	return ep
+}
+
+// resolve resolves the references in the group and the endpoints string values.
+func (g *mappingGroup) resolve(i Interpolation) error {
+	for _, v := range []interface{}{&g.Prefix, &g.TopicPrefix, &g.Headers, &g.Auth, &g.Include} {
+		if err := interpolate(reflect.ValueOf(v).Elem(), i.expand); err != nil {
+			return fmt.Errorf("group %v: %v", g.Prefix, err)
+		}
+	}
+	for _, path := range g.Endpoints {
+		if err := ResolveMapping(path.Endpoints, i); err != nil {
+			return err
+		}
+	}
+	for j := range g.Groups {
+		if err := g.Groups[j].resolve(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeHeaders returns the headers with the overrides, nil if both are empty.
+func mergeHeaders(headers, overrides map[string]string) map[string]string {
+	if len(overrides) == 0 {
+		return headers
+	}
+	if len(headers) == 0 {
+		return overrides
+	}
+
+	merged := map[string]string{}
+	for h, v := range headers {
+		merged[h] = v
+	}
+	for h, v := range overrides {
+		merged[h] = v
+	}
+	return merged
+}
+
+// mappingLoader resolves the groups and the includes of the mapping files to the flat
+// endpoints list.
+type mappingLoader struct {
+	interpolation *Interpolation  // Resolves the references of every file when set
+	files         map[string]bool // Files being loaded
+}
+
+// loadFile loads the mapping file with the parent group values. The decoding errors
+// have the position in the file.
+func (l *mappingLoader) loadFile(path string, parent mappingGroup) ([]Endpoint, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if l.files[abs] {
+		return nil, IncludeError{path, ErrIncludeCycle}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.files == nil {
+		l.files = map[string]bool{}
+	}
+	l.files[abs] = true
+	defer delete(l.files, abs)
+
+	eps, err := l.parse(data, filepath.Dir(path), parent)
+	if perr, ok := err.(ParseError); ok {
+		return nil, newConfigError(path, data, jsonErrorOffset(perr.err, data), perr.err)
+	}
+	return eps, err
+}
+
+// parse parses the mapping, the includes are relative to dir.
+func (l *mappingLoader) parse(data []byte, dir string, parent mappingGroup) ([]Endpoint, error) {
+	root, err := decodeMapping(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.interpolation != nil {
+		i := *l.interpolation
+		i.Dir = dir
+		if err := root.resolve(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return l.flatten(root, dir, parent)
+}
+
+func (l *mappingLoader) flatten(g mappingGroup, dir string, parent mappingGroup) ([]Endpoint, error) {
+	merged := g.inherit(parent)
+
+	mapping := []Endpoint{}
+	for _, path := range g.Endpoints {
+		for _, ep := range path.Endpoints {
+			if err := validateBranches(ep.Aggregate); err != nil {
+				return nil, err
+			}
+			mapping = append(mapping, merged.apply(ep))
+		}
+	}
+
+	for _, sub := range g.Groups {
+		eps, err := l.flatten(sub, dir, merged)
+		if err != nil {
+			return nil, err
+		}
+		mapping = append(mapping, eps...)
+	}
+
+	for _, pattern := range g.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, IncludeError{pattern, err}
+		}
+		if len(files) == 0 {
+			return nil, IncludeError{pattern, ErrNoIncludeFiles}
+		}
+		for _, file := range files {
+			eps, err := l.loadFile(file, merged)
+			if err != nil {
+				return nil, err
+			}
+			mapping = append(mapping, eps...)
+		}
+	}
+
+	return mapping, nil
+}
+
+// decodeMapping decodes the root group of the mapping. The keys are the paths except
+// for the include and groups keys.
+func decodeMapping(data []byte) (mappingGroup, error) {
+	root := mappingGroup{Endpoints: endpointsJSON{}}
+
+	// Checks the syntax of the whole mapping first, the errors have the offset in data
+	if err := json.Unmarshal(data, &map[string]json.RawMessage{}); err != nil {
+		return root, ParseError{err}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		// null
+		return root, nil
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return root, ParseError{err}
+		}
+		key := t.(string)
+
+		// The offsets of the decoder errors are from the start of the value
+		start := dec.InputOffset()
+		for start < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n:"), data[start]) >= 0 {
+			start++
+		}
+
+		switch key {
+		case includeKey:
+			err = dec.Decode(&root.Include)
+		case groupsKey:
+			err = dec.Decode(&root.Groups)
+		default:
+			path := root.Endpoints[key]
+			if err = dec.Decode(&path); err == nil {
+				root.Endpoints[key] = path
+			}
+		}
+		if err != nil {
+			if e, ok := err.(*json.UnmarshalTypeError); ok {
+				e.Offset += start
+			}
+			return root, ParseError{err}
+		}
+	}
+
+	setGroupPaths([]mappingGroup{root})
+
+	return root, nil
+}
+
+// setGroupPaths sets the endpoint paths to the keys of the group endpoints.
+func setGroupPaths(groups []mappingGroup) {
+	for _, g := range groups {
+		for key, path := range g.Endpoints {
+			for i := range path.Endpoints {
+				path.Endpoints[i].Path = key
+			}
+		}
+		setGroupPaths(g.Groups)
+	}
+}
@@ -0,0 +1,140 @@
+package sdk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseMappingGroups(t *testing.T) {
+	eps, err := ParseMapping([]byte(`{
+		"/health": {"endpoints": [{"method": "GET", "topic": "health"}]},
+		"groups": [{
+			"prefix": "/v1",
+			"topicPrefix": "api.",
+			"timeout": 1000,
+			"headers": {"X-Api": "v1", "Cache-Control": "no-store"},
+			"auth": {"claims": ["sub"]},
+			"endpoints": {
+				"/users/:id": {"endpoints": [
+					{"method": "GET", "topic": "users.get", "headers": {"Cache-Control": "max-age=60"}},
+					{"method": "DELETE", "topic": "users.delete", "keepAlive": 5000, "auth": {"claims": ["admin"]}}
+				]},
+				"/dashboard": {"endpoints": [
+					{"method": "GET", "aggregate": [{"name": "a", "topic": "a.get"}]}
+				]}
+			},
+			"groups": [{
+				"prefix": "/public",
+				"topicPrefix": "public.",
+				"auth": {},
+				"endpoints": {"": {"endpoints": [{"method": "GET", "topic": "index"}]}}
+			}]
+		}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(eps, func(i, j int) bool {
+		return eps[i].Method+" "+eps[i].Path < eps[j].Method+" "+eps[j].Path
+	})
+	v1 := map[string]string{"X-Api": "v1", "Cache-Control": "no-store"}
+	expected := []Endpoint{
+		{
+			Path: "/v1/users/:id", Method: "DELETE", Topic: "api.users.delete", KeepAlive: 5000,
+			Headers: v1, Auth: &EndpointAuth{Claims: []string{"admin"}},
+		},
+		{Path: "/health", Method: "GET", Topic: "health"},
+		{
+			Path: "/v1/dashboard", Method: "GET", KeepAlive: 1000,
+			Aggregate: []Branch{{Name: "a", Topic: "api.a.get"}},
+			Headers:   v1, Auth: &EndpointAuth{Claims: []string{"sub"}},
+		},
+		{Path: "/v1/public", Method: "GET", Topic: "api.public.index", KeepAlive: 1000, Headers: v1, Auth: &EndpointAuth{}},
+		{
+			Path: "/v1/users/:id", Method: "GET", Topic: "api.users.get", KeepAlive: 1000,
+			Headers: map[string]string{"X-Api": "v1", "Cache-Control": "max-age=60"},
+			Auth:    &EndpointAuth{Claims: []string{"sub"}},
+		},
+	}
+	if !reflect.DeepEqual(eps, expected) {
+		t.Errorf("Unexpected endpoints\nExpected: %+v\nReceived: %+v", expected, eps)
+	}
+}
+
+func TestLoadMappingIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "includes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "users"), 0700)
+	os.Mkdir(filepath.Join(dir, "cycle"), 0700)
+
+	files := map[string]string{
+		"endpoints.json": `{
+			"/health": {"endpoints": [{"method": "GET", "topic": "health"}]},
+			"groups": [{"prefix": "/users", "topicPrefix": "users.", "include": ["users/*.json"]}]
+		}`,
+		"users/get.json":    `{"/:id": {"endpoints": [{"method": "GET", "topic": "get"}]}}`,
+		"users/delete.json": `{"include": ["../roles.json"], "/:id": {"endpoints": [{"method": "DELETE", "topic": "delete"}]}}`,
+		"roles.json":        `{"/:id/roles": {"endpoints": [{"method": "GET", "topic": "roles", "security": {"csp": "file://users/csp"}}]}}`,
+		"users/csp":         "default-src 'none'\n",
+		"missing.json":      `{"include": ["none/*.json"]}`,
+		"cycle/a.json":      `{"include": ["b.json"]}`,
+		"cycle/b.json":      `{"include": ["a.json"]}`,
+		"broken.json":       `{"include": ["users/broken.txt"]}`,
+		"users/broken.txt":  "{\n\t\"/a\": {\"endpoints\": [{\"method\": 1}]}\n}",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	eps, err := LoadMapping(filepath.Join(dir, "endpoints.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := []string{}
+	for _, ep := range eps {
+		routes = append(routes, fmt.Sprintf("%v %v %v", ep.Method, ep.Path, ep.Topic))
+		if ep.Topic == "users.roles" && ep.Security.CSP != "default-src 'none'" {
+			t.Errorf("Unexpected CSP of the included file: %q", ep.Security.CSP)
+		}
+	}
+	sort.Strings(routes)
+	expected := []string{
+		"DELETE /users/:id users.delete",
+		"GET /health health",
+		"GET /users/:id users.get",
+		"GET /users/:id/roles users.roles",
+	}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("Unexpected routes\nExpected: %v\nReceived: %v", expected, routes)
+	}
+
+	cases := []struct {
+		file string
+		err  string
+	}{
+		{file: "missing.json", err: "no files match the pattern"},
+		{file: "cycle/a.json", err: "a.json: include cycle"},
+		{file: "broken.json", err: "broken.txt:2:35: json: cannot unmarshal number"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			_, err := LoadMapping(filepath.Join(dir, tc.file))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Unexpected error: got %v want %v", err, tc.err)
+			}
+		})
+	}
+}
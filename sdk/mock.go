@@ -0,0 +1,268 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+// Group of the mock topics replacing the templated topics.
+const mockGroup = "mock"
+
+// ErrFixtureTopic is returned when the endpoints sharing a topic have different fixtures.
+var ErrFixtureTopic = errors.New("different fixture of the shared topic")
+
+// Fixture is the mocked service response of an endpoint.
+//
+// Body is a template rendered with TransformData with the path and query parameters,
+// the request headers and the JSON body. ErrorRate is the probability the mock responds
+// with ErrorStatus instead, Latency delays every response.
+type Fixture struct {
+	Status  int               `json:"status,omitempty"` // Defaults to 200
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+
+	Latency     int     `json:"latency,omitempty"`     // In Millisecond
+	ErrorRate   float64 `json:"errorRate,omitempty"`   // From 0 to 1
+	ErrorStatus int     `json:"errorStatus,omitempty"` // Defaults to 500
+
+	// Fixtures of the aggregate branches by name, the branches without one use the
+	// endpoint fixture
+	Branches map[string]*Fixture `json:"branches,omitempty"`
+}
+
+// Fixtures are the endpoint fixtures by path and method, the methods are case insensitive.
+type Fixtures map[string]map[string]*Fixture
+
+// LoadFixtures reads the fixtures file. The references in the string values are
+// resolved with Interpolation, e.g. "body": "file://users/get.json" reads the body
+// template from the file.
+func LoadFixtures(path string) (Fixtures, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures := Fixtures{}
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, newConfigError(path, data, jsonErrorOffset(err, data), err)
+	}
+
+	interpolation := Interpolation{Dir: filepath.Dir(path)}
+	if err := interpolate(reflect.ValueOf(fixtures), interpolation.expand); err != nil {
+		return nil, ConfigError{File: path, err: err}
+	}
+
+	return fixtures, nil
+}
+
+// NewMockService returns the service serving the endpoints from the fixtures on the
+// mem transport, the proxy runs the full request processing without the services.
+//
+// The returned endpoints have to be used instead of eps, the templated topics are
+// replaced with the mock topics. The endpoints without fixture respond with 501.
+func NewMockService(eps []Endpoint, fixtures Fixtures) (*mrpc.Service, []Endpoint, error) {
+	transport := mem.New()
+	m := &mock{
+		newService: func(name, group string) (*mrpc.Service, error) {
+			return mrpc.NewService(transport, mrpc.WithNGV(name, group, ""))
+		},
+		services: map[string]*mrpc.Service{},
+		topics:   map[string]*Fixture{},
+	}
+
+	byMethod := map[string]*Fixture{}
+	for path, methods := range fixtures {
+		for method, f := range methods {
+			byMethod[strings.ToUpper(method)+" "+path] = f
+		}
+	}
+
+	found := map[string]bool{}
+	mocked := make([]Endpoint, len(eps))
+	for i, ep := range eps {
+		key := strings.ToUpper(ep.Method) + " " + ep.Path
+		f := byMethod[key]
+		found[key] = true
+
+		var err error
+		if len(ep.Aggregate) == 0 {
+			ep.Topic, err = m.handle(mockTopic(ep, ""), ep.Topic, f)
+		} else {
+			branches := make([]Branch, len(ep.Aggregate))
+			for j, b := range ep.Aggregate {
+				bf := f
+				if f != nil && f.Branches[b.Name] != nil {
+					bf = f.Branches[b.Name]
+				}
+				b.Topic, err = m.handle(mockTopic(ep, b.Name), b.Topic, bf)
+				if err != nil {
+					break
+				}
+				branches[j] = b
+			}
+			ep.Aggregate = branches
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("fixture %v %v: %v", ep.Method, ep.Path, err)
+		}
+		mocked[i] = ep
+	}
+
+	for key := range byMethod {
+		if !found[key] {
+			return nil, nil, fmt.Errorf("fixture %v: no endpoint", key)
+		}
+	}
+
+	service, err := m.newService("mrpcproxy", "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return service, mocked, nil
+}
+
+// mock subscribes the fixture handlers to the topics.
+type mock struct {
+	newService func(name, group string) (*mrpc.Service, error) // On the mem transport
+	services   map[string]*mrpc.Service                        // By group
+	topics     map[string]*Fixture
+}
+
+// handle subscribes the fixture to the topic and returns it. The templated topics and
+// the topics without group are replaced with the mock topic.
+func (m *mock) handle(mockTopic, topic string, f *Fixture) (string, error) {
+	if strings.Contains(topic, "{{") || !strings.Contains(topic, ".") {
+		topic = mockTopic
+	}
+	if shared, ok := m.topics[topic]; ok {
+		// Shared by several endpoints, served by the first fixture
+		if !reflect.DeepEqual(shared, f) {
+			return "", fmt.Errorf("topic %v: %v", topic, ErrFixtureTopic)
+		}
+		return topic, nil
+	}
+
+	h, err := newFixtureHandler(f)
+	if err != nil {
+		return "", err
+	}
+
+	parts := strings.SplitN(topic, ".", 2)
+	service, ok := m.services[parts[0]]
+	if !ok {
+		service, err = m.newService(parts[0], parts[0])
+		if err != nil {
+			return "", err
+		}
+		m.services[parts[0]] = service
+	}
+	if err := service.HandleFunc(parts[1], h); err != nil {
+		return "", err
+	}
+
+	m.topics[topic] = f
+	return topic, nil
+}
+
+// mockTopic returns the mock topic of the endpoint or the aggregate branch.
+func mockTopic(ep Endpoint, branch string) string {
+	// The raw path keeps the topics of /users/:id and /users/id apart, # isn't in the paths.
+	// The escaping keeps e.g. the * of the catch-all parameters out of the topic.
+	topic := mockGroup + "." + strings.ToLower(ep.Method) + topicEscaper.Replace(ep.Path)
+	if branch != "" {
+		topic += "#" + topicEscaper.Replace(branch)
+	}
+	return topic
+}
+
+func newFixtureHandler(f *Fixture) (func(mrpc.TopicWriter, []byte), error) {
+	if f == nil {
+		return func(w mrpc.TopicWriter, data []byte) {
+			writeMockResponse(w, &mrpcproxy.Response{Code: http.StatusNotImplemented, Msg: []byte("no fixture")})
+		}, nil
+	}
+
+	tmpl, err := template.New("body").Funcs(transformFuncs).Parse(f.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	status := f.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	errorStatus := f.ErrorStatus
+	if errorStatus == 0 {
+		errorStatus = http.StatusInternalServerError
+	}
+	headers := http.Header{}
+	for h, v := range f.Headers {
+		headers.Set(h, v)
+	}
+
+	return func(w mrpc.TopicWriter, data []byte) {
+		req := &mrpcproxy.Request{}
+		if err := json.Unmarshal(data, req); err != nil {
+			writeMockResponse(w, &mrpcproxy.Response{Code: http.StatusBadRequest, Msg: []byte(err.Error())})
+			return
+		}
+
+		time.Sleep(time.Duration(f.Latency) * time.Millisecond)
+
+		res := &mrpcproxy.Response{RequestID: req.RequestID, Code: status, Headers: headers}
+		if f.ErrorRate > 0 && rand.Float64() < f.ErrorRate {
+			res.Code = errorStatus
+			res.Headers = nil
+			res.Msg = []byte(http.StatusText(errorStatus))
+			writeMockResponse(w, res)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, newMockData(req)); err != nil {
+			res.Code = http.StatusInternalServerError
+			res.Msg = []byte(err.Error())
+		} else {
+			res.Msg = buf.Bytes()
+		}
+		writeMockResponse(w, res)
+	}, nil
+}
+
+// newMockData returns the template data of the request.
+func newMockData(req *mrpcproxy.Request) *TransformData {
+	data := &TransformData{
+		Method:  req.Action,
+		Params:  map[string]string{},
+		Query:   req.Params,
+		Headers: req.Headers,
+	}
+	for name := range req.Params {
+		data.Params[name] = req.Params.Get(name)
+	}
+	if len(req.Msg) > 0 {
+		if err := json.Unmarshal(req.Msg, &data.Body); err != nil {
+			data.Body = string(req.Msg)
+		}
+	}
+	return data
+}
+
+func writeMockResponse(w mrpc.TopicWriter, res *mrpcproxy.Response) {
+	msg, _ := json.Marshal(res)
+	w.Write(msg)
+}
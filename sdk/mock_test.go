@@ -0,0 +1,190 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMockService(t *testing.T) {
+	eps := []Endpoint{
+		{Path: "/users/:id", Method: "GET", Topic: "users.get.{{.id}}"},
+		{Path: "/users", Method: "POST", Topic: "users.create"},
+		{Path: "/slow", Method: "GET", Topic: "slow", KeepAlive: 10},
+		{Path: "/flaky", Method: "GET", Topic: "flaky.get"},
+		{Path: "/todo", Method: "GET", Topic: "todo.get"},
+		{Path: "/files/*path", Method: "GET", Topic: "files.get.{{.path}}"},
+		{Path: "/dashboard", Method: "GET", Aggregate: []Branch{
+			{Name: "user", Topic: "users.get.{{.id}}"},
+			{Name: "stats", Topic: "stats.get"},
+		}},
+	}
+	fixtures := Fixtures{
+		"/users/:id": {"get": {
+			Headers: map[string]string{"Cache-Control": "max-age=60"},
+			Body:    `{"id": "{{.Params.id}}", "verbose": {{or .Params.verbose "false"}}}`,
+		}},
+		"/users":       {"POST": {Status: 201, Body: `{"name": {{json .Body.name}}}`}},
+		"/slow":        {"GET": {Latency: 100, Body: "late"}},
+		"/flaky":       {"GET": {ErrorRate: 1, ErrorStatus: 503, Body: "ok"}},
+		"/files/*path": {"GET": {Body: "{{.Params.path}}"}},
+		"/dashboard": {"GET": {
+			Body:     `{"visits": 1}`,
+			Branches: map[string]*Fixture{"user": {Body: `{"id": "{{.Params.id}}"}`}},
+		}},
+	}
+
+	service, mocked, err := NewMockService(eps, fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mocked[0].Topic != "mock.get/users/:id" || mocked[1].Topic != "users.create" || mocked[2].Topic != "mock.get/slow" {
+		t.Errorf("Unexpected topics: %v, %v, %v", mocked[0].Topic, mocked[1].Topic, mocked[2].Topic)
+	}
+	if eps[0].Topic != "users.get.{{.id}}" {
+		t.Errorf("Endpoints changed: %v", eps[0].Topic)
+	}
+	if b := mocked[6].Aggregate; b[0].Topic != "mock.get/dashboard#user" || b[1].Topic != "stats.get" {
+		t.Errorf("Unexpected branch topics: %v, %v", b[0].Topic, b[1].Topic)
+	}
+
+	pxy, _ := New(":80", service, func(pxy *Proxy) error {
+		pxy.Timeout = time.Second
+		return nil
+	})
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.Debugger = &MockLogger{}
+	if err := pxy.Handle(mocked...); err != nil {
+		t.Fatal(err)
+	}
+	pxy.setupRouter()
+
+	cases := []struct {
+		method string
+		url    string
+		body   string
+		status int
+		res    string
+		header string
+	}{
+		{method: "GET", url: "/users/42", status: 200, res: `{"id": "42", "verbose": false}`, header: "max-age=60"},
+		{method: "GET", url: "/users/42?verbose=true", status: 200, res: `{"id": "42", "verbose": true}`, header: "max-age=60"},
+		{method: "POST", url: "/users", body: `{"name": "Al"}`, status: 201, res: `{"name": "Al"}`},
+		{method: "GET", url: "/slow", status: 408},
+		{method: "GET", url: "/flaky", status: 503, res: "Service Unavailable"},
+		{method: "GET", url: "/todo", status: 501, res: "no fixture"},
+		{method: "GET", url: "/files/a/b.txt", status: 200, res: "/a/b.txt"},
+		{
+			method: "GET",
+			url:    "/dashboard?id=7",
+			status: 200,
+			res:    `{"stats":{"status":200,"body":{"visits":1}},"user":{"status":200,"body":{"id":"7"}}}`,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			pxy.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body)))
+
+			if rr.Code != tc.status {
+				t.Fatalf("Unexpected status: got %v want %v", rr.Code, tc.status)
+			}
+			if body := rr.Body.String(); body != tc.res {
+				t.Errorf("Unexpected body: got %v want %v", body, tc.res)
+			}
+			if rr.Header().Get("Cache-Control") != tc.header {
+				t.Errorf("Unexpected Cache-Control: %v", rr.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func TestMockTopic(t *testing.T) {
+	cases := []struct {
+		ep     Endpoint
+		branch string
+		topic  string
+	}{
+		{ep: Endpoint{Path: "/users/:id", Method: "GET"}, topic: "mock.get/users/:id"},
+		{ep: Endpoint{Path: "/users/id", Method: "GET"}, topic: "mock.get/users/id"},
+		{ep: Endpoint{Path: "/städte/*ort", Method: "PUT"}, topic: "mock.put/städte/%2Aort"},
+		{ep: Endpoint{Path: "/v1.0/users", Method: "GET"}, topic: "mock.get/v1%2E0/users"},
+		{ep: Endpoint{Path: "/dashboard", Method: "GET"}, branch: "user", topic: "mock.get/dashboard#user"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			if topic := mockTopic(tc.ep, tc.branch); topic != tc.topic {
+				t.Errorf("Unexpected topic: got %v want %v", topic, tc.topic)
+			}
+		})
+	}
+}
+
+func TestMockServiceErrors(t *testing.T) {
+	eps := []Endpoint{
+		{Path: "/a", Method: "GET", Topic: "service.a"},
+		{Path: "/b", Method: "GET", Topic: "service.b"},
+		{Path: "/b/:id", Method: "GET", Topic: "service.b"},
+	}
+
+	cases := []struct {
+		fixtures Fixtures
+		err      string
+	}{
+		{fixtures: Fixtures{"/c": {"GET": {}}}, err: "fixture GET /c: no endpoint"},
+		{fixtures: Fixtures{"/a": {"POST": {}}}, err: "fixture POST /a: no endpoint"},
+		{fixtures: Fixtures{"/a": {"GET": {Body: "{{.id"}}}, err: "fixture GET /a: template: body:1: unclosed action"},
+		{
+			fixtures: Fixtures{"/b": {"GET": {Body: "b"}}, "/b/:id": {"GET": {Body: "{{.Params.id}}"}}},
+			err:      "fixture GET /b/:id: topic service.b: different fixture of the shared topic",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			_, _, err := NewMockService(eps, tc.fixtures)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Unexpected error: got %v want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestLoadFixtures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	user := map[string]string{"id": "{{.Params.id}}"}
+	body, _ := json.Marshal(user)
+	ioutil.WriteFile(filepath.Join(dir, "user.json"), body, 0600)
+	ioutil.WriteFile(filepath.Join(dir, "fixtures.json"), []byte(`{
+		"/users/:id": {"GET": {"body": "file://user.json", "latency": 50}}
+	}`), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte("{\n\t\"/a\": {\"GET\": {\"latency\": \"1s\"}}\n}"), 0600)
+
+	fixtures, err := LoadFixtures(filepath.Join(dir, "fixtures.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := fixtures["/users/:id"]["GET"]
+	if f.Body != string(body) || f.Latency != 50 {
+		t.Errorf("Unexpected fixture: %+v", f)
+	}
+
+	_, err = LoadFixtures(filepath.Join(dir, "broken.json"))
+	if err == nil || !strings.Contains(err.Error(), "broken.json:2:32: json: cannot unmarshal string") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,566 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// OpenAPIVersion is the version of the generated OpenAPI documents.
+const OpenAPIVersion = "3.0.3"
+
+// Route of the OpenAPI document served by the proxy.
+const openAPIRoute = "/openapi.json"
+
+// OpenAPIInfo is the info object of the OpenAPI document.
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPIDocument is the OpenAPI 3 document of the endpoints. Paths are keyed by the
+// OpenAPI path and the lower case method.
+type OpenAPIDocument struct {
+	OpenAPI string                                  `json:"openapi"`
+	Info    OpenAPIInfo                             `json:"info"`
+	Paths   map[string]map[string]*OpenAPIOperation `json:"paths"`
+}
+
+// OpenAPIOperation is the operation of a single endpoint. The x-mrpc extensions have the
+// topic, the aggregate branches, the timeout and the async flag of the endpoint.
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Parameters  []OpenAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+
+	Topic     string   `json:"x-mrpc-topic,omitempty"`
+	Aggregate []Branch `json:"x-mrpc-aggregate,omitempty"`
+	Timeout   int      `json:"x-mrpc-timeout,omitempty"` // In Millisecond
+	Async     bool     `json:"x-mrpc-async,omitempty"`
+}
+
+// OpenAPIParameter is a path, query or header parameter.
+type OpenAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *OpenAPISchema `json:"schema,omitempty"`
+
+	CatchAll bool `json:"x-mrpc-catchall,omitempty"` // The *name httprouter parameter
+}
+
+// OpenAPISchema is the schema of a parameter or a reference to the body schema. Enum
+// values are any JSON values, e.g. the numbers of an integer schema.
+type OpenAPISchema struct {
+	Ref     string        `json:"$ref,omitempty"`
+	Type    string        `json:"type,omitempty"`
+	Format  string        `json:"format,omitempty"`
+	Pattern string        `json:"pattern,omitempty"`
+	Enum    []interface{} `json:"enum,omitempty"`
+}
+
+// OpenAPIRequestBody is the request body of the operation.
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required,omitempty"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIResponse is a response of the operation.
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// OpenAPIMediaType is the schema of the body with the content type.
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema,omitempty"`
+}
+
+// NewOpenAPIDocument returns the OpenAPI document of the endpoints. The httprouter
+// parameters :name and *name are converted to {name}, the parameter constraints to the
+// parameter schemas and the topic headers to the header parameters.
+func NewOpenAPIDocument(info OpenAPIInfo, eps []Endpoint) *OpenAPIDocument {
+	doc := &OpenAPIDocument{
+		OpenAPI: OpenAPIVersion,
+		Info:    info,
+		Paths:   map[string]map[string]*OpenAPIOperation{},
+	}
+
+	for _, ep := range eps {
+		path, params := openAPIPath(ep.Path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*OpenAPIOperation{}
+		}
+		doc.Paths[path][strings.ToLower(ep.Method)] = newOpenAPIOperation(ep, params)
+	}
+
+	return doc
+}
+
+func newOpenAPIOperation(ep Endpoint, pathParams []string) *OpenAPIOperation {
+	op := &OpenAPIOperation{
+		OperationID: operationID(ep),
+		Description: ep.Description,
+		Responses:   map[string]*OpenAPIResponse{},
+		Topic:       ep.Topic,
+		Aggregate:   ep.Aggregate,
+		Timeout:     ep.KeepAlive,
+		Async:       ep.Async,
+	}
+
+	inPath := map[string]bool{}
+	for _, name := range pathParams {
+		inPath[name] = true
+		op.Parameters = append(op.Parameters, OpenAPIParameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   paramSchema(ep.Params[name]),
+			CatchAll: strings.Contains(ep.Path, "*"+name),
+		})
+	}
+	query := []string{}
+	for name := range ep.Params {
+		if !inPath[name] {
+			query = append(query, name)
+		}
+	}
+	sort.Strings(query)
+	for _, name := range query {
+		op.Parameters = append(op.Parameters, OpenAPIParameter{
+			Name:   name,
+			In:     "query",
+			Schema: paramSchema(ep.Params[name]),
+		})
+	}
+	for _, header := range ep.TopicHeaders {
+		op.Parameters = append(op.Parameters, OpenAPIParameter{
+			Name:   http.CanonicalHeaderKey(header),
+			In:     "header",
+			Schema: &OpenAPISchema{Type: "string"},
+		})
+	}
+
+	if ep.RequestSchema != "" {
+		op.RequestBody = &OpenAPIRequestBody{
+			Required: true,
+			Content:  jsonContent(ep.RequestSchema),
+		}
+	}
+
+	switch {
+	case ep.Async:
+		op.Responses["202"] = &OpenAPIResponse{Description: "Accepted, the job status is at the Location URL"}
+	case ep.ResponseSchema != "":
+		op.Responses["200"] = &OpenAPIResponse{Description: "Service response", Content: jsonContent(ep.ResponseSchema)}
+	default:
+		op.Responses["default"] = &OpenAPIResponse{Description: "Service response"}
+	}
+
+	return op
+}
+
+// openAPIPath converts the httprouter path to the OpenAPI path and returns the names of
+// the path parameters.
+func openAPIPath(path string) (string, []string) {
+	params := []string{}
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if len(s) > 1 && (s[0] == ':' || s[0] == '*') {
+			params = append(params, s[1:])
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// operationID returns the method and the path segments in camel case, e.g.
+// getUsersId for GET /users/:id.
+func operationID(ep Endpoint) string {
+	id := strings.ToLower(ep.Method)
+	words := strings.FieldsFunc(ep.Path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		r, size := utf8.DecodeRuneInString(w)
+		id += string(unicode.ToUpper(r)) + w[size:]
+	}
+	return id
+}
+
+func paramSchema(c ParamConstraint) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "string", Pattern: c.Pattern}
+	switch c.Type {
+	case "int":
+		schema.Type = "integer"
+	case "uuid":
+		schema.Format = "uuid"
+	}
+	for _, e := range c.Enum {
+		// The integer enums are numbers
+		if n, err := strconv.ParseInt(e, 10, 64); err == nil && schema.Type == "integer" {
+			schema.Enum = append(schema.Enum, n)
+		} else {
+			schema.Enum = append(schema.Enum, e)
+		}
+	}
+	return schema
+}
+
+func jsonContent(ref string) map[string]OpenAPIMediaType {
+	return map[string]OpenAPIMediaType{
+		"application/json": {Schema: &OpenAPISchema{Ref: ref}},
+	}
+}
+
+// OpenAPIDocument returns the OpenAPI document of the proxy endpoints with the proxy
+// timeout as the default timeout.
+func (pxy *Proxy) OpenAPIDocument(info OpenAPIInfo) *OpenAPIDocument {
+	return pxy.openAPIDocument(info, pxy.Eps)
+}
+
+func (pxy *Proxy) openAPIDocument(info OpenAPIInfo, eps []Endpoint) *OpenAPIDocument {
+	documented := make([]Endpoint, len(eps))
+	for i, ep := range eps {
+		ep.KeepAlive = int(pxy.endpointTimeout(ep).Milliseconds())
+		documented[i] = ep
+	}
+	return NewOpenAPIDocument(info, documented)
+}
+
+// handleOpenAPI serves the OpenAPI document of the endpoints registered so far. Each
+// listener documents only its endpoints, so the internal endpoints and their topics
+// aren't exposed on the public listeners.
+func (pxy *Proxy) handleOpenAPI() error {
+	return pxy.handleEndpoints("GET", openAPIRoute, pxy.openAPIHandler)
+}
+
+func (pxy *Proxy) openAPIHandler(eps []Endpoint) (httprouter.Handle, error) {
+	// The clients don't need the MRPC topics, the aggregate branches and the timeouts
+	public := pxy.openAPIDocument(*pxy.OpenAPI, eps)
+	for _, ops := range public.Paths {
+		for _, op := range ops {
+			op.Topic, op.Aggregate, op.Timeout = "", nil, 0
+		}
+	}
+
+	doc, err := json.Marshal(public)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		pxy.setHeaders(w)
+		w.Header().Set("Content-Type", "application/json")
+		pxy.Requests.Printf("%v:%v, status: %v", r.Method, r.URL.Path, http.StatusOK)
+		if _, err := w.Write(doc); err != nil {
+			pxy.Debugger.Println(err)
+		}
+	}, nil
+}
+
+var (
+	// ErrOpenAPIVersion is returned when the imported document isn't OpenAPI 3
+	ErrOpenAPIVersion = errors.New("unsupported OpenAPI version")
+	// ErrNoTopic is returned when the imported operation has no x-mrpc-topic, x-mrpc-aggregate
+	// or operationId
+	ErrNoTopic = errors.New("no x-mrpc-topic or operationId")
+	// ErrPartialParam is returned when the imported path parameter isn't a whole path segment,
+	// e.g. /files/{id}.json
+	ErrPartialParam = errors.New("path parameter isn't a whole path segment")
+)
+
+// OpenAPIError is returned when the operation of the OpenAPI document can't be imported.
+type OpenAPIError struct {
+	Method, Path string
+	err          error
+}
+
+func (e OpenAPIError) Error() string {
+	return fmt.Sprintf("%v %v: %v", e.Method, e.Path, e.err)
+}
+
+// Methods of the OpenAPI path item, the other path item fields aren't operations.
+var openAPIMethods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+// ParseOpenAPI parses the OpenAPI 3 JSON or YAML document. The path item parameters are
+// added to the operations, the $ref parameters and the other path item fields are ignored.
+func ParseOpenAPI(data []byte) (*OpenAPIDocument, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '{' {
+		var err error
+		if data, _, err = yamlToJSON(data); err != nil {
+			return nil, err
+		}
+	}
+
+	raw := struct {
+		OpenAPI string                                `json:"openapi"`
+		Info    OpenAPIInfo                           `json:"info"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(raw.OpenAPI, "3.") {
+		return nil, ErrOpenAPIVersion
+	}
+
+	doc := &OpenAPIDocument{
+		OpenAPI: raw.OpenAPI,
+		Info:    raw.Info,
+		Paths:   map[string]map[string]*OpenAPIOperation{},
+	}
+	for path, item := range raw.Paths {
+		shared := []OpenAPIParameter{}
+		if params, ok := item["parameters"]; ok {
+			if err := json.Unmarshal(params, &shared); err != nil {
+				return nil, fmt.Errorf("%v: %v", path, err)
+			}
+		}
+
+		doc.Paths[path] = map[string]*OpenAPIOperation{}
+		for method, v := range item {
+			if !openAPIMethods[method] {
+				continue
+			}
+			op := &OpenAPIOperation{}
+			if err := json.Unmarshal(v, op); err != nil {
+				return nil, OpenAPIError{strings.ToUpper(method), path, err}
+			}
+			op.Parameters = mergeParameters(shared, op.Parameters)
+			doc.Paths[path][method] = op
+		}
+	}
+
+	return doc, nil
+}
+
+// mergeParameters returns the parameters with the overrides of the same name and location.
+func mergeParameters(params, overrides []OpenAPIParameter) []OpenAPIParameter {
+	merged := []OpenAPIParameter{}
+	for _, p := range params {
+		overridden := false
+		for _, o := range overrides {
+			if o.Name == p.Name && o.In == p.In {
+				overridden = true
+			}
+		}
+		if !overridden {
+			merged = append(merged, p)
+		}
+	}
+	return append(merged, overrides...)
+}
+
+// EndpointsFromOpenAPI returns the endpoints of the OpenAPI document operations sorted by
+// path and method. The topic is x-mrpc-topic or OperationTopic of the operationId.
+//
+// The parameter schemas are converted to the parameter constraints, the header
+// parameters to the topic headers and the application/json $ref schemas of the request
+// and the 200 or 201 response to the endpoint schemas.
+func EndpointsFromOpenAPI(doc *OpenAPIDocument) ([]Endpoint, error) {
+	paths := []string{}
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	eps := []Endpoint{}
+	for _, path := range paths {
+		methods := []string{}
+		for method := range doc.Paths[path] {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+
+		for _, method := range methods {
+			ep, err := openAPIEndpoint(path, method, doc.Paths[path][method])
+			if err != nil {
+				return nil, OpenAPIError{strings.ToUpper(method), path, err}
+			}
+			eps = append(eps, ep)
+		}
+	}
+
+	return eps, nil
+}
+
+func openAPIEndpoint(path, method string, op *OpenAPIOperation) (Endpoint, error) {
+	ep := Endpoint{
+		Method:      strings.ToUpper(method),
+		Topic:       op.Topic,
+		KeepAlive:   op.Timeout,
+		Async:       op.Async,
+		Aggregate:   op.Aggregate,
+		Description: op.Description,
+	}
+	if ep.Topic == "" && len(ep.Aggregate) == 0 {
+		if op.OperationID == "" {
+			return ep, ErrNoTopic
+		}
+		ep.Topic = OperationTopic(op.OperationID)
+	}
+	if err := validateBranches(ep.Aggregate); err != nil {
+		return ep, err
+	}
+
+	catchAll := map[string]bool{}
+	for _, p := range op.Parameters {
+		switch p.In {
+		case "header":
+			ep.TopicHeaders = append(ep.TopicHeaders, p.Name)
+			continue
+		case "path":
+			catchAll[p.Name] = p.CatchAll
+		case "query":
+		default:
+			continue
+		}
+		if c, ok := paramConstraint(p.Schema); ok {
+			if ep.Params == nil {
+				ep.Params = map[string]ParamConstraint{}
+			}
+			ep.Params[p.Name] = c
+		}
+	}
+	var err error
+	if ep.Path, err = routerPath(path, catchAll); err != nil {
+		return ep, err
+	}
+
+	if op.RequestBody != nil {
+		ep.RequestSchema = schemaRef(op.RequestBody.Content)
+	}
+	for _, code := range []string{"200", "201"} {
+		if res := op.Responses[code]; res != nil && ep.ResponseSchema == "" {
+			ep.ResponseSchema = schemaRef(res.Content)
+		}
+	}
+
+	return ep, nil
+}
+
+// routerPath converts the OpenAPI path to the httprouter path, the httprouter parameters
+// are whole path segments.
+func routerPath(path string, catchAll map[string]bool) (string, error) {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if len(s) > 2 && s[0] == '{' && s[len(s)-1] == '}' && strings.Count(s, "{") == 1 {
+			name := s[1 : len(s)-1]
+			if catchAll[name] {
+				segments[i] = "*" + name
+			} else {
+				segments[i] = ":" + name
+			}
+			continue
+		}
+		if strings.ContainsAny(s, "{}") {
+			return "", ErrPartialParam
+		}
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// paramConstraint converts the parameter schema, ok is false for the unconstrained string.
+func paramConstraint(schema *OpenAPISchema) (c ParamConstraint, ok bool) {
+	if schema == nil {
+		return c, false
+	}
+
+	switch {
+	case schema.Type == "integer":
+		c.Type = "int"
+	case schema.Format == "uuid":
+		c.Type = "uuid"
+	}
+	c.Pattern = schema.Pattern
+	for _, e := range schema.Enum {
+		if f, ok := e.(float64); ok {
+			// Not 1e+06
+			c.Enum = append(c.Enum, strconv.FormatFloat(f, 'f', -1, 64))
+		} else {
+			c.Enum = append(c.Enum, fmt.Sprint(e))
+		}
+	}
+	return c, c.Type != "" || c.Pattern != "" || len(c.Enum) > 0
+}
+
+func schemaRef(content map[string]OpenAPIMediaType) string {
+	if schema := content["application/json"].Schema; schema != nil {
+		return schema.Ref
+	}
+	return ""
+}
+
+// OperationTopic returns the topic of the operation without x-mrpc-topic, the lower case
+// words of the operationId joined with dots, e.g. users.get for usersGet or users_get.
+func OperationTopic(operationID string) string {
+	words := []string{}
+	word := []rune{}
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		}
+	}
+
+	prev := rune(0)
+	for _, r := range operationID {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+		case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)):
+			flush()
+			fallthrough
+		default:
+			word = append(word, unicode.ToLower(r))
+		}
+		prev = r
+	}
+	flush()
+
+	return strings.Join(words, ".")
+}
+
+// mappingEndpoint is the endpoint of the endpoints.json mapping, the path is the mapping
+// key.
+type mappingEndpoint struct {
+	Endpoint
+	Path *struct{} `json:"Path,omitempty"` // Hides Endpoint.Path
+}
+
+// MarshalMapping returns the endpoints.json mapping of the endpoints, the endpoints of
+// the path are sorted by method.
+func MarshalMapping(eps []Endpoint) ([]byte, error) {
+	mapping := map[string]struct {
+		Endpoints []mappingEndpoint `json:"endpoints"`
+	}{}
+	for _, ep := range eps {
+		path := mapping[ep.Path]
+		path.Endpoints = append(path.Endpoints, mappingEndpoint{Endpoint: ep})
+		mapping[ep.Path] = path
+	}
+	for _, path := range mapping {
+		sort.SliceStable(path.Endpoints, func(i, j int) bool {
+			return path.Endpoints[i].Method < path.Endpoints[j].Method
+		})
+	}
+
+	return json.MarshalIndent(mapping, "", "\t")
+}
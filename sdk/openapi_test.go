@@ -0,0 +1,383 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+)
+
+func TestOpenAPIPath(t *testing.T) {
+	cases := []struct {
+		path    string
+		openAPI string
+		params  []string
+		id      string
+	}{
+		{path: "/", openAPI: "/", params: []string{}, id: "get"},
+		{path: "/users", openAPI: "/users", params: []string{}, id: "getUsers"},
+		{path: "/users/:id", openAPI: "/users/{id}", params: []string{"id"}, id: "getUsersId"},
+		{path: "/users/:id/roles/:role", openAPI: "/users/{id}/roles/{role}", params: []string{"id", "role"}, id: "getUsersIdRolesRole"},
+		{path: "/files/*path", openAPI: "/files/{path}", params: []string{"path"}, id: "getFilesPath"},
+		{path: "/user-groups/:group_id", openAPI: "/user-groups/{group_id}", params: []string{"group_id"}, id: "getUserGroupsGroupId"},
+		{path: "/städte/:ort", openAPI: "/städte/{ort}", params: []string{"ort"}, id: "getStädteOrt"},
+		{path: "/éclairs", openAPI: "/éclairs", params: []string{}, id: "getÉclairs"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			path, params := openAPIPath(tc.path)
+			if path != tc.openAPI {
+				t.Errorf("Unexpected path: got %v want %v", path, tc.openAPI)
+			}
+			if !reflect.DeepEqual(params, tc.params) {
+				t.Errorf("Unexpected params: got %v want %v", params, tc.params)
+			}
+			if id := operationID(Endpoint{Method: "GET", Path: tc.path}); id != tc.id {
+				t.Errorf("Unexpected operation ID: got %v want %v", id, tc.id)
+			}
+		})
+	}
+}
+
+func TestNewOpenAPIDocument(t *testing.T) {
+	doc := NewOpenAPIDocument(OpenAPIInfo{Title: "API", Version: "1.0"}, []Endpoint{
+		{
+			Path: "/users/:id", Method: "PUT", Topic: "users.update", KeepAlive: 2000,
+			Description:    "Updates the user",
+			RequestSchema:  "#/components/schemas/User",
+			ResponseSchema: "#/components/schemas/User",
+			Params: map[string]ParamConstraint{
+				"id":      {Type: "uuid"},
+				"version": {Type: "int"},
+				"mode":    {Enum: []string{"merge", "replace"}},
+			},
+			TopicHeaders: []string{"x-tenant"},
+		},
+		{Path: "/users/:id", Method: "DELETE", Topic: "users.delete", Async: true},
+	})
+
+	expected := &OpenAPIDocument{
+		OpenAPI: OpenAPIVersion,
+		Info:    OpenAPIInfo{Title: "API", Version: "1.0"},
+		Paths: map[string]map[string]*OpenAPIOperation{
+			"/users/{id}": {
+				"put": {
+					OperationID: "putUsersId",
+					Description: "Updates the user",
+					Parameters: []OpenAPIParameter{
+						{Name: "id", In: "path", Required: true, Schema: &OpenAPISchema{Type: "string", Format: "uuid"}},
+						{Name: "mode", In: "query", Schema: &OpenAPISchema{Type: "string", Enum: []interface{}{"merge", "replace"}}},
+						{Name: "version", In: "query", Schema: &OpenAPISchema{Type: "integer"}},
+						{Name: "X-Tenant", In: "header", Schema: &OpenAPISchema{Type: "string"}},
+					},
+					RequestBody: &OpenAPIRequestBody{
+						Required: true,
+						Content:  map[string]OpenAPIMediaType{"application/json": {Schema: &OpenAPISchema{Ref: "#/components/schemas/User"}}},
+					},
+					Responses: map[string]*OpenAPIResponse{
+						"200": {
+							Description: "Service response",
+							Content:     map[string]OpenAPIMediaType{"application/json": {Schema: &OpenAPISchema{Ref: "#/components/schemas/User"}}},
+						},
+					},
+					Topic:   "users.update",
+					Timeout: 2000,
+				},
+				"delete": {
+					OperationID: "deleteUsersId",
+					Parameters:  []OpenAPIParameter{{Name: "id", In: "path", Required: true, Schema: &OpenAPISchema{Type: "string"}}},
+					Responses:   map[string]*OpenAPIResponse{"202": {Description: "Accepted, the job status is at the Location URL"}},
+					Topic:       "users.delete",
+					Async:       true,
+				},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(doc, expected) {
+		got, _ := json.MarshalIndent(doc, "", "  ")
+		want, _ := json.MarshalIndent(expected, "", "  ")
+		t.Errorf("Unexpected document\nExpected: %s\nReceived: %s", want, got)
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	pxy, _ := New(":80", service, func(pxy *Proxy) error {
+		pxy.Timeout = 3 * time.Second
+		pxy.OpenAPI = &OpenAPIInfo{Title: "API", Version: "2.0"}
+		return nil
+	})
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.Debugger = &MockLogger{}
+	pxy.Handle(
+		Endpoint{Path: "/a", Method: "GET", Topic: "service.a"},
+		Endpoint{Path: "/b", Method: "GET", Topic: "service.b", KeepAlive: 500},
+		Endpoint{Path: "/c", Method: "GET", Topic: "service.c", Listeners: []string{"internal"}},
+	)
+	pxy.setupRouter()
+
+	rr := httptest.NewRecorder()
+	pxy.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rr.Code != 200 || rr.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected response: %v %v", rr.Code, rr.Header())
+	}
+
+	doc := &OpenAPIDocument{}
+	if err := json.Unmarshal(rr.Body.Bytes(), doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Info.Version != "2.0" || len(doc.Paths) != 2 {
+		t.Errorf("Unexpected document: %+v", doc)
+	}
+	// The served document doesn't expose the MRPC extensions
+	for _, ext := range []string{"x-mrpc-topic", "x-mrpc-aggregate", "x-mrpc-timeout", "service."} {
+		if strings.Contains(rr.Body.String(), ext) {
+			t.Errorf("Unexpected %v in %v", ext, rr.Body.String())
+		}
+	}
+
+	full := pxy.OpenAPIDocument(*pxy.OpenAPI)
+	if op := full.Paths["/a"]["get"]; op.Timeout != 3000 || op.Topic != "service.a" {
+		t.Errorf("Unexpected default timeout: %v", op.Timeout)
+	}
+	if timeout := full.Paths["/b"]["get"].Timeout; timeout != 500 {
+		t.Errorf("Unexpected endpoint timeout: %v", timeout)
+	}
+
+	// The listeners document only their endpoints
+	cases := []struct {
+		listener string
+		paths    []string
+	}{
+		{listener: "public", paths: []string{"/a", "/b"}},
+		{listener: "internal", paths: []string{"/a", "/b", "/c"}},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			router, err := pxy.listenerRouter(tc.listener)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+
+			doc := &OpenAPIDocument{}
+			if err := json.Unmarshal(rr.Body.Bytes(), doc); err != nil {
+				t.Fatal(err)
+			}
+			paths := []string{}
+			for path := range doc.Paths {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			if !reflect.DeepEqual(paths, tc.paths) {
+				t.Errorf("Unexpected paths: got %v want %v", paths, tc.paths)
+			}
+		})
+	}
+}
+
+func TestOperationTopic(t *testing.T) {
+	cases := []struct {
+		id    string
+		topic string
+	}{
+		{id: "usersGet", topic: "users.get"},
+		{id: "users_get", topic: "users.get"},
+		{id: "users.get", topic: "users.get"},
+		{id: "getUserByID", topic: "get.user.by.id"},
+		{id: "UsersGet", topic: "users.get"},
+		{id: "v2UsersList", topic: "v2.users.list"},
+		{id: "users--get", topic: "users.get"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			if topic := OperationTopic(tc.id); topic != tc.topic {
+				t.Errorf("Unexpected topic: got %v want %v", topic, tc.topic)
+			}
+		})
+	}
+}
+
+func TestEndpointsFromOpenAPI(t *testing.T) {
+	doc, err := ParseOpenAPI([]byte(`{
+		"openapi": "3.0.1",
+		"info": {"title": "API", "version": "1.0"},
+		"paths": {
+			"/users/{id}": {
+				"summary": "User",
+				"parameters": [
+					{"name": "id", "in": "path", "required": true, "schema": {"type": "string", "format": "uuid"}},
+					{"name": "X-Tenant", "in": "header", "schema": {"type": "string"}}
+				],
+				"get": {
+					"operationId": "usersGet",
+					"description": "Returns the user",
+					"parameters": [{"name": "fields", "in": "query", "schema": {"type": "string"}}],
+					"responses": {"200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}}}
+				},
+				"put": {
+					"x-mrpc-topic": "users.update.{{.id}}",
+					"x-mrpc-timeout": 2000,
+					"parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "enum": [1, 2, 1000000]}}],
+					"requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+					"responses": {
+						"204": {"description": "Updated"},
+						"400": {"description": "Invalid", "content": {"application/json": {"schema": {"type": "integer", "enum": [1, 2]}}}}
+					}
+				}
+			},
+			"/files/{path}": {
+				"get": {
+					"operationId": "files_get",
+					"parameters": [{"name": "path", "in": "path", "required": true, "x-mrpc-catchall": true}],
+					"responses": {"default": {"description": "File"}}
+				}
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eps, err := EndpointsFromOpenAPI(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Endpoint{
+		{Path: "/files/*path", Method: "GET", Topic: "files.get"},
+		{
+			Path: "/users/:id", Method: "GET", Topic: "users.get",
+			Description:    "Returns the user",
+			Params:         map[string]ParamConstraint{"id": {Type: "uuid"}},
+			TopicHeaders:   []string{"X-Tenant"},
+			ResponseSchema: "#/components/schemas/User",
+		},
+		{
+			Path: "/users/:id", Method: "PUT", Topic: "users.update.{{.id}}", KeepAlive: 2000,
+			Params:        map[string]ParamConstraint{"id": {Type: "int", Enum: []string{"1", "2", "1000000"}}},
+			TopicHeaders:  []string{"X-Tenant"},
+			RequestSchema: "#/components/schemas/User",
+		},
+	}
+	if !reflect.DeepEqual(eps, expected) {
+		t.Errorf("Unexpected endpoints\nExpected: %+v\nReceived: %+v", expected, eps)
+	}
+
+	// The exported mapping is parsed back to the same endpoints
+	mapping, err := MarshalMapping(eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseMapping(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != len(eps) || bytes.Contains(mapping, []byte(`"Path"`)) {
+		t.Errorf("Unexpected mapping: %s", mapping)
+	}
+}
+
+func TestParseOpenAPIYAML(t *testing.T) {
+	doc, err := ParseOpenAPI([]byte(`
+openapi: 3.0.1
+info: {title: API, version: "1.0"}
+paths:
+  /users/{id}:
+    get:
+      x-mrpc-topic: users.get
+      x-mrpc-timeout: 500
+      responses: {"200": {description: OK}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eps, err := EndpointsFromOpenAPI(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Endpoint{{Path: "/users/:id", Method: "GET", Topic: "users.get", KeepAlive: 500}}
+	if !reflect.DeepEqual(eps, expected) {
+		t.Errorf("Unexpected endpoints\nExpected: %+v\nReceived: %+v", expected, eps)
+	}
+}
+
+func TestEndpointsFromOpenAPIErrors(t *testing.T) {
+	cases := []struct {
+		doc string
+		err string
+	}{
+		{doc: `{"openapi": "2.0"}`, err: "unsupported OpenAPI version"},
+		{doc: `{"openapi": "3.0.0", "paths": {"/a": {"get": {"responses": {}}}}}`, err: "GET /a: no x-mrpc-topic or operationId"},
+		{doc: `{"openapi": "3.0.0", "paths": {"/a": {"get": {"x-mrpc-timeout": "1s"}}}}`, err: "GET /a: json: cannot unmarshal"},
+		{
+			doc: `{"openapi": "3.0.0", "paths": {"/a": {"get": {"x-mrpc-aggregate": [{"name": "b", "topic": "b"}, {"name": "b", "topic": "c"}]}}}}`,
+			err: "GET /a: aggregate branch names",
+		},
+		{
+			doc: `{"openapi": "3.0.0", "paths": {"/files/{id}.json": {"get": {"operationId": "filesGet"}}}}`,
+			err: "GET /files/{id}.json: path parameter isn't a whole path segment",
+		},
+		{
+			doc: `{"openapi": "3.0.0", "paths": {"/files/{dir}{name}": {"get": {"operationId": "filesGet"}}}}`,
+			err: "GET /files/{dir}{name}: path parameter isn't a whole path segment",
+		},
+		{doc: "openapi: [3.0.0", err: "yaml: line 1"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			doc, err := ParseOpenAPI([]byte(tc.doc))
+			if err == nil {
+				_, err = EndpointsFromOpenAPI(doc)
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Unexpected error: got %v want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestOpenAPIRoundTrip(t *testing.T) {
+	eps := []Endpoint{
+		{
+			Path: "/files/*path", Method: "GET", Topic: "files.get", KeepAlive: 1000,
+			Params: map[string]ParamConstraint{"path": {Pattern: "^[a-z/]+$"}},
+		},
+		{
+			Path: "/users/:id", Method: "POST", Topic: "users.create", Async: true,
+			RequestSchema: "user.json", Description: "Creates the user",
+		},
+		{
+			Path: "/users/:id", Method: "PUT", Aggregate: []Branch{{Name: "a", Topic: "a"}},
+			Params: map[string]ParamConstraint{"mode": {Enum: []string{"a", "b"}}, "n": {Type: "int", Enum: []string{"1", "2"}}},
+		},
+	}
+
+	data, _ := json.Marshal(NewOpenAPIDocument(OpenAPIInfo{Title: "API", Version: "1"}, eps))
+	doc, err := ParseOpenAPI(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := EndpointsFromOpenAPI(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imported, eps) {
+		t.Errorf("Unexpected endpoints\nExpected: %+v\nReceived: %+v", eps, imported)
+	}
+}
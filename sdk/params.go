@@ -0,0 +1,164 @@
+package sdk
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Escaping policies for the parameter values used in topic templates.
+const (
+	// EscapeParams percent-encodes the characters with special meaning in topics. It's
+	// the default policy.
+	EscapeParams = "escape"
+	// RejectParams rejects requests with path parameters containing special characters.
+	// The query and header values are still escaped.
+	RejectParams = "reject"
+	// RawParams uses the parameters as they are.
+	RawParams = "raw"
+)
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// topicEscaper encodes the characters that change the topic structure. '%' is encoded
+// too so the escaped values are unambiguous.
+var topicEscaper = strings.NewReplacer(
+	"%", "%25",
+	".", "%2E",
+	"*", "%2A",
+	">", "%3E",
+	" ", "%20",
+	"\t", "%09",
+	"\r", "%0D",
+	"\n", "%0A",
+)
+
+// ParamConstraint restricts the values of a path or query parameter.
+type ParamConstraint struct {
+	Type    string   `json:"type,omitempty"` // int or uuid
+	Pattern string   `json:"pattern,omitempty"`
+	Enum    []string `json:"enum,omitempty"`
+}
+
+// ParamError is returned when a parameter violates the endpoint constraints or the
+// escaping policy.
+type ParamError struct {
+	Name   string
+	Value  string
+	Reason string
+}
+
+func (e ParamError) Error() string {
+	return fmt.Sprintf("invalid parameter %v=%q: %v", e.Name, e.Value, e.Reason)
+}
+
+type paramRule struct {
+	ParamConstraint
+	pattern *regexp.Regexp
+}
+
+// paramRules are the compiled parameter constraints of an endpoint.
+type paramRules struct {
+	rules  map[string]paramRule
+	escape string
+}
+
+func newParamRules(ep Endpoint) (*paramRules, error) {
+	pr := &paramRules{
+		rules:  map[string]paramRule{},
+		escape: ep.ParamEscaping,
+	}
+
+	switch pr.escape {
+	case "":
+		pr.escape = EscapeParams
+	case EscapeParams, RejectParams, RawParams:
+	default:
+		return nil, fmt.Errorf("unknown parameter escaping policy: %v", ep.ParamEscaping)
+	}
+
+	for name, c := range ep.Params {
+		rule := paramRule{ParamConstraint: c}
+		switch c.Type {
+		case "", "int", "uuid":
+		default:
+			return nil, fmt.Errorf("unknown type of parameter %v: %v", name, c.Type)
+		}
+		if c.Pattern != "" {
+			var err error
+			rule.pattern, err = regexp.Compile(c.Pattern)
+			if err != nil {
+				return nil, err
+			}
+		}
+		pr.rules[name] = rule
+	}
+
+	return pr, nil
+}
+
+// check validates the path and query parameters.
+func (pr *paramRules) check(p httprouter.Params, query url.Values) error {
+	for _, param := range p {
+		if pr.escape == RejectParams && topicEscaper.Replace(param.Value) != param.Value {
+			return ParamError{param.Key, param.Value, "contains characters reserved in topics"}
+		}
+		if err := pr.checkValue(param.Key, param.Value); err != nil {
+			return err
+		}
+	}
+	for name, values := range query {
+		for _, v := range values {
+			if err := pr.checkValue(name, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (pr *paramRules) checkValue(name, value string) error {
+	rule, ok := pr.rules[name]
+	if !ok {
+		return nil
+	}
+
+	switch rule.Type {
+	case "int":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return ParamError{name, value, "not an integer"}
+		}
+	case "uuid":
+		if !uuidRegexp.MatchString(value) {
+			return ParamError{name, value, "not an UUID"}
+		}
+	}
+
+	if rule.pattern != nil && !rule.pattern.MatchString(value) {
+		return ParamError{name, value, fmt.Sprintf("doesn't match %v", rule.Pattern)}
+	}
+
+	if len(rule.Enum) > 0 {
+		for _, e := range rule.Enum {
+			if e == value {
+				return nil
+			}
+		}
+		return ParamError{name, value, fmt.Sprintf("not one of %v", strings.Join(rule.Enum, ", "))}
+	}
+
+	return nil
+}
+
+// escapeValue applies the escaping policy to a value used in topic template.
+func (pr *paramRules) escapeValue(value string) string {
+	if pr.escape == RawParams {
+		return value
+	}
+	return topicEscaper.Replace(value)
+}
@@ -0,0 +1,116 @@
+package sdk
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestParamRulesCheck(t *testing.T) {
+	ep := Endpoint{
+		Params: map[string]ParamConstraint{
+			"id":   {Type: "int"},
+			"uid":  {Type: "uuid"},
+			"name": {Pattern: "^[a-z]+$"},
+			"kind": {Enum: []string{"a", "b"}},
+		},
+	}
+
+	cases := []struct {
+		escaping string
+		params   httprouter.Params
+		query    url.Values
+		err      error
+	}{
+		{
+			params: httprouter.Params{{Key: "id", Value: "12"}, {Key: "name", Value: "abc"}},
+			query:  url.Values{"kind": {"a"}},
+		},
+		{
+			params: httprouter.Params{{Key: "uid", Value: "3f0e9b9c-1d5a-4b1e-8b8a-2c1b1f7b6e2a"}},
+		},
+		{
+			params: httprouter.Params{{Key: "id", Value: "1.2"}},
+			err:    ParamError{"id", "1.2", "not an integer"},
+		},
+		{
+			params: httprouter.Params{{Key: "uid", Value: "abc"}},
+			err:    ParamError{"uid", "abc", "not an UUID"},
+		},
+		{
+			params: httprouter.Params{{Key: "name", Value: "a>"}},
+			err:    ParamError{"name", "a>", "doesn't match ^[a-z]+$"},
+		},
+		{
+			query: url.Values{"kind": {"c"}},
+			err:   ParamError{"kind", "c", "not one of a, b"},
+		},
+		{
+			params: httprouter.Params{{Key: "other", Value: "a.b"}},
+		},
+		{
+			escaping: RejectParams,
+			params:   httprouter.Params{{Key: "other", Value: "a.b"}},
+			err:      ParamError{"other", "a.b", "contains characters reserved in topics"},
+		},
+		{
+			escaping: RejectParams,
+			query:    url.Values{"other": {"a.b"}},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			ep := ep
+			ep.ParamEscaping = tc.escaping
+			rules, err := newParamRules(ep)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := rules.check(tc.params, tc.query); err != tc.err {
+				t.Errorf("Unexpected error: got %v want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestParamRulesEscape(t *testing.T) {
+	cases := []struct {
+		escaping string
+		value    string
+		result   string
+	}{
+		{"", "a.b", "a%2Eb"},
+		{EscapeParams, "> *%", "%3E%20%2A%25"},
+		{RejectParams, "a.b", "a%2Eb"},
+		{RawParams, "a.b", "a.b"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			rules, _ := newParamRules(Endpoint{ParamEscaping: tc.escaping})
+			if v := rules.escapeValue(tc.value); v != tc.result {
+				t.Errorf("Unexpected value: got %v want %v", v, tc.result)
+			}
+		})
+	}
+}
+
+func TestNewParamRulesError(t *testing.T) {
+	cases := []Endpoint{
+		{ParamEscaping: "unknown"},
+		{Params: map[string]ParamConstraint{"id": {Type: "float"}}},
+		{Params: map[string]ParamConstraint{"id": {Pattern: "("}}},
+	}
+
+	for i, ep := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			if _, err := newParamRules(ep); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
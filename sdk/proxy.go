@@ -1,18 +1,18 @@
 package sdk
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
-	"text/template"
+	"sync"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
@@ -39,15 +39,75 @@ type Proxy struct {
 	MRPCService *mrpc.Service
 	Timeout     time.Duration
 
+	// HTTPS configuration used by ServeTLS
+	TLS *TLSOptions
+
 	// Request ID generator
 	GetID func() string
 
+	// Claims of the authenticated caller, available to the endpoint templates
+	Claims func(r *http.Request) map[string]interface{}
+	// GatewayHeaders are removed from the requests not coming from the TrustedProxies,
+	// e.g. the claim headers set by the authenticating gateway
+	GatewayHeaders []string
+	TrustedProxies []*net.IPNet
+
 	// List of headers that will be added to every response
 	Headers map[string]string
 	Handler func(w http.ResponseWriter, r *http.Request, res *mrpcproxy.Response)
 
-	Eps    []Endpoint
-	router *httprouter.Router
+	// Headers the services can't set or override
+	ProtectedHeaders []string
+	// Security headers set on every response, Endpoint.Security overrides them
+	Security *SecurityHeaders
+
+	// Response cache of the endpoints with Endpoint.Cache, defaults to in-memory LRU
+	Cache     Cache
+	flights   map[string]*flight
+	flightsMu sync.Mutex
+
+	// Generate ETags and respond 304 to matching If-None-Match
+	ETags bool
+
+	// Response compression and request decompression, disabled if nil
+	Compression *Compression
+
+	// Cross-Origin Resource Sharing configuration, Endpoint.CORS overrides it
+	CORS         *CORS
+	corsPolicies map[string]*corsPolicy
+
+	Eps         []Endpoint
+	router      *httprouter.Router // The routes of the default listener
+	allRoutes   *httprouter.Router // The routes of all the listeners, checks the conflicts
+	routes      []route
+	routerReady bool
+
+	// Additional addresses served together with the address passed to New
+	Listeners []Listener
+	servers   []*http.Server
+	serversMu sync.Mutex
+
+	// Health, readiness and liveness endpoints, disabled if nil
+	Health  *Health
+	checked checkedHealth
+
+	// Info of the OpenAPI document served at /openapi.json, disabled if nil
+	OpenAPI *OpenAPIInfo
+
+	// Admin API served by ServeAdmin, disabled if nil
+	Admin *Admin
+
+	// Time Stop waits after the readiness flip before it stops accepting connections
+	DrainGrace time.Duration
+	draining   int32
+	inFlight   inFlight
+
+	// Async endpoints, StatusPath and ResultTopic are set before the first one is handled
+	Jobs        JobStore // Defaults to in-memory store
+	StatusPath  string   // Job status route, defaults to /jobs/:id
+	ResultTopic string   // Topic the services publish async job results to
+	async       *asyncSetup
+	jobRequests jobRequests
 
 	Debugger logger
 	Logger   logger
@@ -84,13 +144,14 @@ func New(addr string, s *mrpc.Service, opts ...func(*Proxy) error) (*Proxy, erro
 	}
 	r := httprouter.New()
 	pxy := &Proxy{
-		http:        &http.Server{Addr: addr, Handler: r},
+		http:        &http.Server{Addr: addr},
 		MRPCService: s,
 		Timeout:     defaultTimeout,
 
 		GetID: func() string { return "" },
 
-		router: r,
+		router:    r,
+		allRoutes: httprouter.New(),
 
 		Debugger: defaultDebugger,
 		Logger:   defaultLogger,
@@ -103,6 +164,8 @@ func New(addr string, s *mrpc.Service, opts ...func(*Proxy) error) (*Proxy, erro
 		}
 	}
 
+	pxy.http.Handler = pxy
+
 	return pxy, nil
 }
 
@@ -110,56 +173,188 @@ func New(addr string, s *mrpc.Service, opts ...func(*Proxy) error) (*Proxy, erro
 func (pxy *Proxy) Handle(eps ...Endpoint) error {
 	pxy.Eps = append(pxy.Eps, eps...)
 	for _, ep := range eps {
+		if ep.Async {
+			if err := pxy.handleAsync(); err != nil {
+				return err
+			}
+		}
+		if ep.Cache != nil && pxy.Cache == nil {
+			pxy.Cache = NewLRUCache(defaultCacheSize)
+		}
+
 		h, err := pxy.getTopicHandler(ep)
 		if err != nil {
 			return err
 		}
-		pxy.router.Handle(ep.Method, ep.Path, h)
+		h, err = pxy.withCORS(ep, pxy.withAuth(ep, pxy.track(ep, pxy.observe(ep, h))))
+		if err != nil {
+			return err
+		}
+		if err := pxy.handle(ep.Method, ep.Path, ep.Listeners, pxy.withSecurity(ep, h)); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// Serve starts the HTTP server.
+// Serve starts the HTTP server. With Listeners the proxy serves on all of them and the
+// address passed to New.
 func (pxy *Proxy) Serve() error {
-	pxy.router.NotFound = &notFoundHandler{pxy.Requests}
+	if err := pxy.setupRouter(); err != nil {
+		return err
+	}
+	if err := pxy.checkListeners(pxy.listeners()); err != nil {
+		return err
+	}
+	if len(pxy.Listeners) > 0 {
+		return pxy.serveListeners(nil)
+	}
+	return pxy.http.ListenAndServe()
+}
 
-	for _, ep := range pxy.Eps {
+// setupRouter adds the handlers that depend on all the endpoints being registered. It
+// runs once, the next Serve calls use the same router.
+func (pxy *Proxy) setupRouter() error {
+	if pxy.routerReady {
+		return nil
+	}
+
+	if pxy.Health != nil {
+		if err := pxy.handleHealth(); err != nil {
+			return err
+		}
+	}
+	if pxy.OpenAPI != nil {
+		if err := pxy.handleOpenAPI(); err != nil {
+			return err
+		}
+	}
+	pxy.configureRouter(pxy.router, pxy.listenerEps(DefaultListener))
+
+	pxy.routerReady = true
+	return nil
+}
+
+func (pxy *Proxy) configureRouter(router *httprouter.Router, eps []Endpoint) {
+	router.NotFound = &notFoundHandler{pxy.Requests}
+	router.HandleMethodNotAllowed = true
+	router.MethodNotAllowed = &methodNotAllowedHandler{pxy, router}
+
+	for _, ep := range eps {
 		if ep.Method == "OPTIONS" {
 			continue
 		}
 
-		h, _, _ := pxy.router.Lookup("OPTIONS", ep.Path)
+		h, _, _ := router.Lookup("OPTIONS", ep.Path)
 		if h == nil {
-			pxy.router.Handle("OPTIONS", ep.Path, pxy.defaultOptionsHandler)
+			router.Handle("OPTIONS", ep.Path, pxy.optionsHandler(ep.Path, pathMethods(eps, ep.Path)))
 		}
-	}
-
-	return pxy.http.ListenAndServe()
-}
 
-// Stop shutdowns the HTTP server
-func (pxy *Proxy) Stop(ctx context.Context) error {
-	return pxy.http.Shutdown(ctx)
+		// GET endpoints respond to HEAD without the body
+		if ep.Method == "GET" {
+			h, _, _ := router.Lookup("HEAD", ep.Path)
+			if h == nil {
+				get, _, _ := router.Lookup("GET", ep.Path)
+				router.Handle("HEAD", ep.Path, headHandler(get))
+			}
+		}
+	}
 }
 
 func (pxy *Proxy) getTopicHandler(ep Endpoint) (httprouter.Handle, error) {
-	topicTmpl, err := template.New("topic").Parse(ep.Topic)
+	if len(ep.Aggregate) > 0 {
+		return pxy.getAggregateHandler(ep)
+	}
+
+	topicTmpl, err := parseTopic("topic", ep.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	transform, err := newBodyTransform(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := newParamRules(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	validator, err := newRequestValidator(ep)
 	if err != nil {
 		return nil, err
 	}
 
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if err := rules.check(p, r.URL.Query()); err != nil {
+			pxy.Debugger.Println(err)
+			pxy.Requests.Printf("%v:%v, status: %v", r.Method, r.URL.Path, http.StatusBadRequest)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		ep := ep // The topic is rendered for every request
 		var err error
-		ep.Topic, err = getTopic(topicTmpl, p)
+		ep.Topic, err = getTopic(topicTmpl, pxy.topicData(r, p, ep, rules))
 		if err != nil {
+			status := http.StatusInternalServerError
+			if _, ok := err.(TopicError); ok {
+				status = http.StatusBadRequest
+			}
+			pxy.Debugger.Println(err)
+			pxy.Requests.Printf("%v:%v, status: %v, topic: %v", r.Method, r.URL.Path, status, ep.Topic)
+			w.WriteHeader(status)
+			return
+		}
+		pxy.inFlight.setTopic(r, ep.Topic)
+
+		if pxy.Compression != nil {
+			if err := pxy.Compression.decompressRequest(r); err != nil {
+				status := decompressStatus(err)
+				pxy.Debugger.Println(err)
+				pxy.Requests.Printf("%v:%v, status: %v, topic: %v", r.Method, r.URL.Path, status, ep.Topic)
+				w.WriteHeader(status)
+				return
+			}
+		}
+
+		if !pxy.validateRequest(w, r, validator, ep.Topic) {
+			return
+		}
+
+		if err := transform.request(pxy, r, p); err != nil {
 			pxy.Debugger.Println(err)
 			pxy.Requests.Printf("%v:%v, status: %v, topic: %v", r.Method, r.URL.Path, http.StatusInternalServerError, ep.Topic)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		res, err := pxy.mrpcRequest(r, p, ep)
+		if ep.Async {
+			if err := pxy.asyncRequest(w, r, p, ep); err != nil {
+				pxy.Debugger.Println(err)
+				pxy.Requests.Printf("%v:%v, status: %v, topic: %v", r.Method, r.URL.Path, http.StatusInternalServerError, ep.Topic)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+
+		var res *mrpcproxy.Response
+		cached := ""
+		if ep.Cache != nil && ep.Method == "GET" {
+			var hit bool
+			res, hit, err = pxy.cachedRequest(r, p, ep)
+			cached = "MISS"
+			if hit {
+				cached = "HIT"
+			}
+		} else {
+			res, err = pxy.mrpcRequest(r, p, ep)
+		}
+		if err == nil {
+			err = transform.response(pxy, r, p, res)
+		}
 		if err != nil {
 			pxy.Debugger.Println(err)
 			pxy.Requests.Printf("%v:%v, status: %v, topic: %v", r.Method, r.URL.Path, http.StatusInternalServerError, ep.Topic)
@@ -169,45 +364,58 @@ func (pxy *Proxy) getTopicHandler(ep Endpoint) (httprouter.Handle, error) {
 
 		// Set default headers
 		pxy.setHeaders(w)
+		setEndpointHeaders(w, ep)
 
 		// Set custom response headers
-		for header, values := range res.Headers {
-			for _, v := range values {
-				w.Header().Set(header, v)
+		pxy.setServiceHeaders(w, res.Headers)
+		if cached != "" {
+			w.Header().Set("X-Cache", cached)
+		}
+
+		status := res.Code
+		if pxy.ETags && res.Code == http.StatusOK {
+			tag := etag(res)
+			w.Header().Set("ETag", tag)
+			if pxy.notModified(r, tag) {
+				status = http.StatusNotModified
 			}
 		}
 
-		pxy.Requests.Printf("%v:%v, status: %v, topic: %v, Id: %v", r.Method, r.URL.Path, res.Code, ep.Topic, res.RequestID)
+		pxy.Requests.Printf("%v:%v, status: %v, topic: %v, Id: %v", r.Method, r.URL.Path, status, ep.Topic, res.RequestID)
 
 		// Run custom handler
 		if pxy.Handler != nil {
 			pxy.Handler(w, r, res)
 		}
 
-		w.WriteHeader(res.Code)
-		if _, err := w.Write(res.Msg); err != nil {
+		if status == http.StatusNotModified {
+			pxy.setNotModifiedETag(w, r, ep, res.Msg)
+			w.WriteHeader(status)
+			return
+		}
+
+		msg := pxy.compress(w, r, ep, res.Msg)
+
+		w.WriteHeader(status)
+		if _, err := w.Write(msg); err != nil {
 			pxy.Logger.Printf("writing to http.ResponseWriter failed: %v", err)
 		}
 	}, nil
 }
 
-func getTopic(t *template.Template, p httprouter.Params) (string, error) {
-	params := map[string]string{}
-	for _, p := range p {
-		params[p.Key] = p.Value
-	}
-	var topicBuf bytes.Buffer
-	if err := t.Execute(&topicBuf, params); err != nil {
-		return "", err
+// compress returns the message compressed if the proxy and the endpoint allow it.
+func (pxy *Proxy) compress(w http.ResponseWriter, r *http.Request, ep Endpoint, msg []byte) []byte {
+	if pxy.Compression == nil || ep.NoCompression {
+		return msg
 	}
-	topic, err := ioutil.ReadAll(&topicBuf)
+
+	compressed, err := pxy.Compression.compress(w, r, msg)
 	if err != nil {
-		return "", err
+		pxy.Debugger.Println(err)
+		return msg
 	}
-	if err := t.Execute(&topicBuf, params); err != nil {
-		return "", err
-	}
-	return string(topic), nil
+
+	return compressed
 }
 
 func (pxy *Proxy) mrpcRequest(r *http.Request, p httprouter.Params, ep Endpoint) (*mrpcproxy.Response, error) {
@@ -221,10 +429,7 @@ func (pxy *Proxy) mrpcRequest(r *http.Request, p httprouter.Params, ep Endpoint)
 		return nil, err
 	}
 
-	setTimeout := pxy.Timeout
-	if ep.KeepAlive > 0 {
-		setTimeout = time.Duration(ep.KeepAlive) * time.Millisecond
-	}
+	setTimeout := pxy.endpointTimeout(ep)
 
 	pxy.Logger.Printf("%v:%v, remote Addr: %v, Id: %v", r.Method, r.URL.Path, req.IPAddress, req.RequestID)
 
@@ -248,6 +453,14 @@ func (pxy *Proxy) mrpcRequest(r *http.Request, p httprouter.Params, ep Endpoint)
 	return res, nil
 }
 
+// endpointTimeout returns the endpoint KeepAlive or the proxy default timeout.
+func (pxy *Proxy) endpointTimeout(ep Endpoint) time.Duration {
+	if ep.KeepAlive > 0 {
+		return time.Duration(ep.KeepAlive) * time.Millisecond
+	}
+	return pxy.Timeout
+}
+
 func (pxy *Proxy) defaultOptionsHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	pxy.setHeaders(w)
 
@@ -278,6 +491,8 @@ func (pxy *Proxy) newRequestFromHTTP(r *http.Request, p httprouter.Params, ep En
 
 	req.Params = mergeRequestParams(r, p)
 	req.Headers = r.Header
+	req.Preconditions = newPreconditions(r)
+	req.ClientCert = newClientCert(r.TLS)
 
 	req.IPAddress = r.Header.Get("X-Forwarded-For")
 	if req.IPAddress == "" {
@@ -315,3 +530,53 @@ func (h *notFoundHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.Requests.Printf("%v:%v, status: %v", r.Method, r.URL.Path, http.StatusNotFound)
 	w.WriteHeader(http.StatusNotFound)
 }
+
+// methodNotAllowedHandler responds to requests to known paths with unsupported method.
+type methodNotAllowedHandler struct {
+	pxy    *Proxy
+	router *httprouter.Router
+}
+
+func (h *methodNotAllowedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", strings.Join(h.pxy.allowedMethods(h.router, r.URL.Path), ", "))
+	h.pxy.Requests.Printf("%v:%v, status: %v", r.Method, r.URL.Path, http.StatusMethodNotAllowed)
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// allowedMethods returns the methods routed for the request path.
+func (pxy *Proxy) allowedMethods(router *httprouter.Router, path string) []string {
+	candidates := []string{}
+	for _, ep := range pxy.Eps {
+		candidates = append(candidates, ep.Method)
+	}
+	candidates = append(candidates, "GET", "HEAD", "OPTIONS")
+
+	methods := []string{}
+	seen := map[string]bool{}
+	for _, m := range candidates {
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		if h, _, _ := router.Lookup(m, path); h != nil {
+			methods = append(methods, m)
+		}
+	}
+
+	return methods
+}
+
+// headHandler runs the GET handler discarding the response body.
+func headHandler(get httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		get(headResponseWriter{w}, r, p)
+	}
+}
+
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
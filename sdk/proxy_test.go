@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -448,14 +449,19 @@ func TestGetTopicHandler(t *testing.T) {
 }
 
 type MockLogger struct {
+	mu      sync.Mutex
 	storage []string
 }
 
 func (l *MockLogger) Println(v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	l.storage = append(l.storage, fmt.Sprintln(v...))
 }
 
 func (l *MockLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	l.storage = append(l.storage, fmt.Sprintf(format, v...))
 }
 
@@ -631,3 +637,60 @@ func (r *MockReader) Read(p []byte) (n int, err error) {
 	p = r.p
 	return r.n, r.err
 }
+
+func TestMethodNotAllowedAndHead(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("a", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: []byte("a response"), Headers: http.Header{"X-Test": {"a"}}})
+		w.Write(msg)
+	})
+
+	pxy, _ := New(":80", service)
+	pxy.Logger = &MockLogger{}
+	l := &MockLogger{}
+	pxy.Requests = l
+	pxy.Handle(
+		Endpoint{Path: "/a", Method: "GET", Topic: "service.a"},
+		Endpoint{Path: "/a", Method: "PUT", Topic: "service.a"},
+		Endpoint{Path: "/b/:id", Method: "POST", Topic: "service.a"},
+	)
+	pxy.setupRouter()
+
+	cases := []struct {
+		method string
+		path   string
+		status int
+		allow  string
+		body   string
+		log    string
+	}{
+		{"DELETE", "/a", http.StatusMethodNotAllowed, "GET, PUT, HEAD, OPTIONS", "", "DELETE:/a, status: 405"},
+		{"GET", "/b/1", http.StatusMethodNotAllowed, "POST, OPTIONS", "", "GET:/b/1, status: 405"},
+		{"HEAD", "/a", http.StatusOK, "", "", "HEAD:/a, status: 200, topic: service.a, Id: "},
+		{"GET", "/a", http.StatusOK, "", "a response", "GET:/a, status: 200, topic: service.a, Id: "},
+		{"GET", "/c", http.StatusNotFound, "", "", "GET:/c, status: 404"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			pxy.router.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+
+			if rr.Code != tc.status {
+				t.Errorf("Unexpected status: got %v want %v", rr.Code, tc.status)
+			}
+			if allow := rr.Header().Get("Allow"); allow != tc.allow {
+				t.Errorf("Unexpected Allow header: got %v want %v", allow, tc.allow)
+			}
+			if rr.Body.String() != tc.body {
+				t.Errorf("Unexpected body: %v", rr.Body.String())
+			}
+			if tc.status == http.StatusOK && rr.Header().Get("X-Test") != "a" {
+				t.Errorf("Missing response headers: %v", rr.Header())
+			}
+			if l.storage[len(l.storage)-1] != tc.log {
+				t.Errorf("Unexpected log: %v", l.storage[len(l.storage)-1])
+			}
+		})
+	}
+}
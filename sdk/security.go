@@ -0,0 +1,95 @@
+package sdk
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// disabledHeader removes a security header set by the proxy configuration.
+const disabledHeader = "-"
+
+// SecurityHeaders are the security related headers set on every response, including
+// the OPTIONS, 404 and error responses. Empty values are not set.
+//
+// Endpoint.Security overrides the non empty values of the proxy configuration, "-"
+// removes the header for the endpoint. The services can't override the headers set by
+// the proxy configuration.
+type SecurityHeaders struct {
+	HSTS               string `json:"hsts,omitempty"`
+	CSP                string `json:"csp,omitempty"`
+	ContentTypeOptions string `json:"contentTypeOptions,omitempty"`
+	ReferrerPolicy     string `json:"referrerPolicy,omitempty"`
+	PermissionsPolicy  string `json:"permissionsPolicy,omitempty"`
+	FrameOptions       string `json:"frameOptions,omitempty"`
+}
+
+// DefaultSecurityHeaders returns the security headers suitable for JSON APIs.
+func DefaultSecurityHeaders() *SecurityHeaders {
+	return &SecurityHeaders{
+		HSTS:               "max-age=31536000; includeSubDomains",
+		CSP:                "default-src 'none'; frame-ancestors 'none'",
+		ContentTypeOptions: "nosniff",
+		ReferrerPolicy:     "no-referrer",
+		PermissionsPolicy:  "camera=(), geolocation=(), microphone=()",
+		FrameOptions:       "DENY",
+	}
+}
+
+// headers returns the values by header name.
+func (s *SecurityHeaders) headers() map[string]string {
+	return map[string]string{
+		"Strict-Transport-Security": s.HSTS,
+		"Content-Security-Policy":   s.CSP,
+		"X-Content-Type-Options":    s.ContentTypeOptions,
+		"Referrer-Policy":           s.ReferrerPolicy,
+		"Permissions-Policy":        s.PermissionsPolicy,
+		"X-Frame-Options":           s.FrameOptions,
+	}
+}
+
+func (s *SecurityHeaders) set(w http.ResponseWriter) {
+	for header, value := range s.headers() {
+		switch value {
+		case "":
+		case disabledHeader:
+			w.Header().Del(header)
+		default:
+			w.Header().Set(header, value)
+		}
+	}
+}
+
+// ServeHTTP sets the security headers and routes the request.
+func (pxy *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pxy.serveRouter(pxy.router, w, r)
+}
+
+func (pxy *Proxy) serveRouter(router *httprouter.Router, w http.ResponseWriter, r *http.Request) {
+	pxy.removeGatewayHeaders(r)
+	if pxy.Security != nil {
+		pxy.Security.set(w)
+	}
+	router.ServeHTTP(w, r)
+}
+
+// withSecurity wraps the endpoint handler with the endpoint security headers overrides.
+func (pxy *Proxy) withSecurity(ep Endpoint, h httprouter.Handle) httprouter.Handle {
+	if ep.Security == nil {
+		return h
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		ep.Security.set(w)
+		h(w, r, p)
+	}
+}
+
+// securityHeader checks if the header is set by the proxy security configuration.
+func (pxy *Proxy) securityHeader(header string) bool {
+	if pxy.Security == nil {
+		return false
+	}
+	v := pxy.Security.headers()[header]
+	return v != "" && v != disabledHeader
+}
@@ -0,0 +1,96 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	service.HandleFunc("a", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{
+			Code:    200,
+			Headers: http.Header{"X-Frame-Options": {"ALLOWALL"}, "Content-Security-Policy": {"default-src *"}},
+		})
+		w.Write(msg)
+	})
+	service.HandleFunc("e", func(w mrpc.TopicWriter, data []byte) {
+		w.Write([]byte("not a response"))
+	})
+
+	pxy, _ := New(":80", service, func(pxy *Proxy) error {
+		pxy.Security = DefaultSecurityHeaders()
+		pxy.Security.PermissionsPolicy = ""
+		return nil
+	})
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.Debugger = &MockLogger{}
+	pxy.Handle(
+		Endpoint{Path: "/a", Method: "GET", Topic: "service.a"},
+		Endpoint{Path: "/e", Method: "GET", Topic: "service.e"},
+		Endpoint{Path: "/docs", Method: "GET", Topic: "service.a", Security: &SecurityHeaders{
+			CSP:          "default-src 'self'",
+			FrameOptions: "-",
+		}},
+	)
+	pxy.setupRouter()
+
+	defaults := map[string]string{
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+		"Content-Security-Policy":   "default-src 'none'; frame-ancestors 'none'",
+		"X-Content-Type-Options":    "nosniff",
+		"Referrer-Policy":           "no-referrer",
+		"Permissions-Policy":        "",
+		"X-Frame-Options":           "DENY",
+	}
+
+	cases := []struct {
+		method    string
+		path      string
+		status    int
+		overrides map[string]string
+	}{
+		{method: "GET", path: "/a", status: 200},
+		{method: "GET", path: "/e", status: 500},
+		{method: "GET", path: "/404", status: 404},
+		{method: "POST", path: "/a", status: 405},
+		{method: "OPTIONS", path: "/a", status: 200},
+		{
+			method: "GET",
+			path:   "/docs",
+			status: 200,
+			overrides: map[string]string{
+				"Content-Security-Policy": "default-src 'self'",
+				"X-Frame-Options":         "", // Removed and still protected from the service
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			pxy.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+
+			if rr.Code != tc.status {
+				t.Errorf("Unexpected status: got %v want %v", rr.Code, tc.status)
+			}
+
+			for h, v := range defaults {
+				if o, ok := tc.overrides[h]; ok {
+					v = o
+				}
+				if rr.Header().Get(h) != v {
+					t.Errorf("Unexpected %v: got %q want %q", h, rr.Header().Get(h), v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package sdk
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/miracl/mrpcproxy"
+)
+
+const defaultCertReloadInterval = 10 * time.Second
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ErrClientCA is returned when the client CA file has no certificates.
+var ErrClientCA = errors.New("no certificates in client CA file")
+
+// TLSOptions configures the HTTPS server started with ServeTLS.
+//
+// MinVersion and MaxVersion are "1.0" to "1.3", by default 1.2 is the minimum.
+// CipherSuites are the names from crypto/tls, e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+// ClientCAFile enables the client certificate verification, ClientAuth can be
+// "require" (default) or "optional" to verify the certificates only when provided.
+// The subject and SANs of the verified client certificate are forwarded in
+// mrpcproxy.Request.ClientCert.
+type TLSOptions struct {
+	MinVersion     string   `json:"minVersion,omitempty"`
+	MaxVersion     string   `json:"maxVersion,omitempty"`
+	CipherSuites   []string `json:"cipherSuites,omitempty"`
+	ClientCAFile   string   `json:"clientCAFile,omitempty"`
+	ClientAuth     string   `json:"clientAuth,omitempty"`
+	ReloadInterval int      `json:"reloadInterval,omitempty"` // In Millisecond. Certificate files check interval
+}
+
+// ServeTLS starts the HTTPS server. The certificate is reloaded when the files change so
+// rotated certificates are used without restarts. With Listeners HTTPS is served on all
+// of them.
+func (pxy *Proxy) ServeTLS(certFile, keyFile string) error {
+	cfg, err := pxy.tlsConfig(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	if err := pxy.setupRouter(); err != nil {
+		return err
+	}
+	if err := pxy.checkListeners(pxy.listeners()); err != nil {
+		return err
+	}
+	if len(pxy.Listeners) > 0 {
+		return pxy.serveListeners(cfg)
+	}
+	pxy.http.TLSConfig = cfg
+	return pxy.http.ListenAndServeTLS("", "")
+}
+
+func (pxy *Proxy) tlsConfig(certFile, keyFile string) (*tls.Config, error) {
+	opts := pxy.TLS
+	if opts == nil {
+		opts = &TLSOptions{}
+	}
+
+	reloadInterval := time.Duration(opts.ReloadInterval) * time.Millisecond
+	reloader, err := newCertReloader(certFile, keyFile, reloadInterval, pxy.Logger)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+
+	if opts.MinVersion != "" {
+		if cfg.MinVersion, err = tlsVersion(opts.MinVersion); err != nil {
+			return nil, err
+		}
+	}
+	if opts.MaxVersion != "" {
+		if cfg.MaxVersion, err = tlsVersion(opts.MaxVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(opts.CipherSuites) > 0 {
+		suites := map[string]uint16{}
+		for _, s := range tls.CipherSuites() {
+			suites[s.Name] = s.ID
+		}
+		for _, name := range opts.CipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown TLS cipher suite: %v", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+
+	if opts.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, ErrClientCA
+		}
+
+		switch opts.ClientAuth {
+		case "", "require":
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		case "optional":
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("unknown client auth: %v", opts.ClientAuth)
+		}
+	}
+
+	return cfg, nil
+}
+
+func tlsVersion(v string) (uint16, error) {
+	version, ok := tlsVersions[v]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version: %v", v)
+	}
+	return version, nil
+}
+
+// certReloader loads the certificate again when the files modification time changes.
+type certReloader struct {
+	certFile, keyFile string
+	interval          time.Duration
+	logger            logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string, interval time.Duration, l logger) (*certReloader, error) {
+	if interval <= 0 {
+		interval = defaultCertReloadInterval
+	}
+
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		logger:   l,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= r.interval {
+		if err := r.reload(); err != nil {
+			// Keep serving the old certificate
+			r.logger.Printf("reloading TLS certificate failed: %v", err)
+		}
+	}
+
+	return r.cert, nil
+}
+
+// reload loads the certificate if the files changed since the last load.
+func (r *certReloader) reload() error {
+	r.checked = time.Now()
+
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// newClientCert returns the verified client certificate of the connection or nil.
+func newClientCert(state *tls.ConnectionState) *mrpcproxy.ClientCertificate {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := state.VerifiedChains[0][0]
+	c := &mrpcproxy.ClientCertificate{
+		Subject:        cert.Subject.String(),
+		Issuer:         cert.Issuer.String(),
+		SerialNumber:   cert.SerialNumber.String(),
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+	}
+	for _, ip := range cert.IPAddresses {
+		c.IPAddresses = append(c.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		c.URIs = append(c.URIs, uri.String())
+	}
+
+	return c
+}
@@ -0,0 +1,221 @@
+package sdk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miracl/mrpcproxy"
+)
+
+func writeCert(t *testing.T, dir, name string) (certFile, keyFile string, cert *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:              []string{name},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile, _ := writeCert(t, dir, "old.example.com")
+	r, err := newCertReloader(certFile, keyFile, time.Nanosecond, &MockLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, _ := r.getCertificate(nil)
+	if cert.Leaf == nil {
+		cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	}
+	if cn := cert.Leaf.Subject.CommonName; cn != "old.example.com" {
+		t.Fatalf("Unexpected certificate: %v", cn)
+	}
+
+	// Rotate the certificate
+	writeCert(t, dir, "new.example.com")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+
+	cert, _ = r.getCertificate(nil)
+	if cert.Leaf == nil {
+		cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	}
+	if cn := cert.Leaf.Subject.CommonName; cn != "new.example.com" {
+		t.Errorf("Certificate not reloaded: %v", cn)
+	}
+
+	// Broken files keep the old certificate
+	ioutil.WriteFile(certFile, []byte("broken"), 0600)
+	future = future.Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+
+	l := &MockLogger{}
+	r.logger = l
+	if c, _ := r.getCertificate(nil); c != cert {
+		t.Errorf("Unexpected certificate after failed reload")
+	}
+	if len(l.storage) != 1 {
+		t.Errorf("Reload error not logged: %v", l.storage)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile, _ := writeCert(t, dir, "example.com")
+	emptyCA := filepath.Join(dir, "empty.pem")
+	ioutil.WriteFile(emptyCA, []byte{}, 0600)
+
+	cases := []struct {
+		opts       *TLSOptions
+		minVersion uint16
+		maxVersion uint16
+		suites     []uint16
+		clientAuth tls.ClientAuthType
+		err        bool
+	}{
+		{opts: nil, minVersion: tls.VersionTLS12},
+		{opts: &TLSOptions{MinVersion: "1.3"}, minVersion: tls.VersionTLS13},
+		{opts: &TLSOptions{MaxVersion: "1.2"}, minVersion: tls.VersionTLS12, maxVersion: tls.VersionTLS12},
+		{opts: &TLSOptions{MinVersion: "2.0"}, err: true},
+		{
+			opts:       &TLSOptions{CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}},
+			minVersion: tls.VersionTLS12,
+			suites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		},
+		{opts: &TLSOptions{CipherSuites: []string{"TLS_UNKNOWN"}}, err: true},
+		{opts: &TLSOptions{ClientCAFile: certFile}, minVersion: tls.VersionTLS12, clientAuth: tls.RequireAndVerifyClientCert},
+		{opts: &TLSOptions{ClientCAFile: certFile, ClientAuth: "optional"}, minVersion: tls.VersionTLS12, clientAuth: tls.VerifyClientCertIfGiven},
+		{opts: &TLSOptions{ClientCAFile: certFile, ClientAuth: "sometimes"}, err: true},
+		{opts: &TLSOptions{ClientCAFile: emptyCA}, err: true},
+		{opts: &TLSOptions{ClientCAFile: filepath.Join(dir, "missing.pem")}, err: true},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			pxy := &Proxy{TLS: tc.opts, Logger: &MockLogger{}}
+			cfg, err := pxy.tlsConfig(certFile, keyFile)
+			if tc.err {
+				if err == nil {
+					t.Fatal("Expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.MinVersion != tc.minVersion || cfg.MaxVersion != tc.maxVersion {
+				t.Errorf("Unexpected versions: got %v-%v want %v-%v", cfg.MinVersion, cfg.MaxVersion, tc.minVersion, tc.maxVersion)
+			}
+			if !reflect.DeepEqual(cfg.CipherSuites, tc.suites) {
+				t.Errorf("Unexpected cipher suites: got %v want %v", cfg.CipherSuites, tc.suites)
+			}
+			if cfg.ClientAuth != tc.clientAuth {
+				t.Errorf("Unexpected client auth: got %v want %v", cfg.ClientAuth, tc.clientAuth)
+			}
+		})
+	}
+}
+
+func TestNewClientCert(t *testing.T) {
+	cert := &x509.Certificate{
+		SerialNumber:   big.NewInt(42),
+		Subject:        pkix.Name{CommonName: "client", Organization: []string{"MIRACL"}},
+		Issuer:         pkix.Name{CommonName: "ca"},
+		DNSNames:       []string{"client.example.com"},
+		EmailAddresses: []string{"client@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/client"}},
+	}
+
+	cases := []struct {
+		state *tls.ConnectionState
+		cert  *mrpcproxy.ClientCertificate
+	}{
+		{state: nil},
+		{state: &tls.ConnectionState{}},
+		// Unverified certificates are not forwarded
+		{state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		{
+			state: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}},
+			cert: &mrpcproxy.ClientCertificate{
+				Subject:        "CN=client,O=MIRACL",
+				Issuer:         "CN=ca",
+				SerialNumber:   "42",
+				DNSNames:       []string{"client.example.com"},
+				EmailAddresses: []string{"client@example.com"},
+				IPAddresses:    []string{"10.0.0.1"},
+				URIs:           []string{"spiffe://example.com/client"},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			if c := newClientCert(tc.state); !reflect.DeepEqual(c, tc.cert) {
+				t.Errorf("Unexpected client certificate: got %+v want %+v", c, tc.cert)
+			}
+		})
+	}
+}
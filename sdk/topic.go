@@ -0,0 +1,129 @@
+package sdk
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// TopicError is returned when the rendered topic is not a valid subject.
+type TopicError struct {
+	Topic string
+}
+
+func (e TopicError) Error() string {
+	return fmt.Sprintf("invalid topic: %q", e.Topic)
+}
+
+// topicFuncs are the functions available in topic templates.
+var topicFuncs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"default": func(def string, v interface{}) string {
+		if s, ok := v.(string); ok && s != "" {
+			return s
+		}
+		return def
+	},
+	// shard maps the value to one of n shards
+	"shard": func(n int, v string) (int, error) {
+		if n <= 0 {
+			return 0, fmt.Errorf("shard count should be positive: %v", n)
+		}
+		h := fnv.New32a()
+		h.Write([]byte(v))
+		return int(h.Sum32() % uint32(n)), nil
+	},
+}
+
+func parseTopic(name, topic string) (*template.Template, error) {
+	return template.New(name).Funcs(topicFuncs).Parse(topic)
+}
+
+// topicData returns the data available in the topic template. The path parameters are
+// available directly by name, e.g. {{.id}}, for compatibility with the older mappings.
+// The rest is under the reserved keys:
+//
+//	.Method - the HTTP method
+//	.Query  - the query parameters, e.g. {{.Query.Get "page"}}
+//	.Header - the headers selected with Endpoint.TopicHeaders, e.g. {{.Header.Tenant}}
+//	.Claims - the claims returned by Proxy.Claims
+//
+// Path parameters with the same name take precedence over the reserved keys. The
+// parameter, header and string claim values are escaped according to the endpoint policy.
+func (pxy *Proxy) topicData(r *http.Request, p httprouter.Params, ep Endpoint, pr *paramRules) map[string]interface{} {
+	headers := map[string]string{}
+	for _, h := range ep.TopicHeaders {
+		headers[http.CanonicalHeaderKey(h)] = pr.escapeValue(r.Header.Get(h))
+	}
+
+	query := r.URL.Query()
+	for _, values := range query {
+		for i, v := range values {
+			values[i] = pr.escapeValue(v)
+		}
+	}
+
+	data := map[string]interface{}{
+		"Method": r.Method,
+		"Query":  query,
+		"Header": headers,
+	}
+	if pxy.Claims != nil {
+		claims := map[string]interface{}{}
+		for name, v := range pxy.Claims(r) {
+			if s, ok := v.(string); ok {
+				v = pr.escapeValue(s)
+			}
+			claims[name] = v
+		}
+		data["Claims"] = claims
+	}
+
+	for _, param := range p {
+		data[param.Key] = pr.escapeValue(param.Value)
+	}
+
+	return data
+}
+
+// getTopic renders the topic template and validates the result.
+func getTopic(t *template.Template, data interface{}) (string, error) {
+	var topicBuf bytes.Buffer
+	if err := t.Execute(&topicBuf, data); err != nil {
+		return "", err
+	}
+
+	topic := topicBuf.String()
+	if !validTopic(topic) {
+		return topic, TopicError{topic}
+	}
+
+	return topic, nil
+}
+
+// validTopic checks the topic is a dot separated list of non empty tokens without
+// wildcards, whitespace or control characters.
+func validTopic(topic string) bool {
+	for _, token := range strings.Split(topic, ".") {
+		if token == "" {
+			return false
+		}
+		for _, c := range token {
+			switch {
+			case c == '*' || c == '>':
+				return false
+			case c <= ' ' || c == 0x7f:
+				return false
+			}
+		}
+	}
+
+	return true
+}
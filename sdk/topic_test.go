@@ -0,0 +1,202 @@
+package sdk
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+)
+
+func TestGetTopic(t *testing.T) {
+	cases := []struct {
+		topic   string
+		url     string
+		headers map[string]string
+		params  httprouter.Params
+		result  string
+		err     error
+	}{
+		{
+			topic:  "service.a.{{.id}}",
+			url:    "/a/1",
+			params: httprouter.Params{{Key: "id", Value: "1"}},
+			result: "service.a.1",
+		},
+		{
+			topic:  `service.{{lower .Method}}.{{.Query.Get "v"}}`,
+			url:    "/a?v=2",
+			result: "service.post.2",
+		},
+		{
+			topic:   "service.{{.Header.Tenant}}.{{.Claims.sub}}",
+			url:     "/a",
+			headers: map[string]string{"tenant": "t1"},
+			result:  "service.t1.user",
+		},
+		{
+			topic:  `service.{{default "all" .region}}.{{replace "-" "_" .name}}`,
+			url:    "/a/b-c",
+			params: httprouter.Params{{Key: "name", Value: "b-c"}},
+			result: "service.all.b_c",
+		},
+		{
+			topic:  "service.shard{{shard 4 .id}}",
+			url:    "/a/1",
+			params: httprouter.Params{{Key: "id", Value: "1"}},
+			result: "service.shard0",
+		},
+		{
+			topic:  "service.a.{{.id}}",
+			url:    "/a/>",
+			params: httprouter.Params{{Key: "id", Value: ">"}},
+			result: "service.a.>",
+			err:    TopicError{"service.a.>"},
+		},
+		{
+			topic:  "service.a.{{.id}}",
+			url:    "/a/",
+			params: httprouter.Params{{Key: "id", Value: ""}},
+			result: "service.a.",
+			err:    TopicError{"service.a."},
+		},
+		{
+			topic:  "service.a.{{.id}}",
+			url:    "/a/b%20c",
+			params: httprouter.Params{{Key: "id", Value: "b c"}},
+			result: "service.a.b c",
+			err:    TopicError{"service.a.b c"},
+		},
+	}
+
+	service, _ := mrpc.NewService(mem.New())
+	pxy, _ := New(":80", service)
+	pxy.Claims = func(r *http.Request) map[string]interface{} {
+		return map[string]interface{}{"sub": "user"}
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			tmpl, err := parseTopic("topic", tc.topic)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest("POST", tc.url, nil)
+			for h, v := range tc.headers {
+				r.Header.Set(h, v)
+			}
+
+			ep := Endpoint{TopicHeaders: []string{"tenant"}, ParamEscaping: RawParams}
+			rules, _ := newParamRules(ep)
+			topic, err := getTopic(tmpl, pxy.topicData(r, tc.params, ep, rules))
+			if err != tc.err {
+				t.Errorf("Unexpected error: got %v want %v", err, tc.err)
+			}
+			if topic != tc.result {
+				t.Errorf("Unexpected topic: got %v want %v", topic, tc.result)
+			}
+		})
+	}
+}
+
+func TestTopicDataClaims(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	pxy, _ := New(":80", service)
+	pxy.Claims = func(r *http.Request) map[string]interface{} {
+		return map[string]interface{}{"sub": "a.b", "level": 2}
+	}
+
+	cases := []struct {
+		escaping string
+		result   string
+	}{
+		{escaping: EscapeParams, result: "service.a%2Eb.2"},
+		{escaping: RejectParams, result: "service.a%2Eb.2"},
+		{escaping: RawParams, result: "service.a.b.2"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			tmpl, err := parseTopic("topic", "service.{{.Claims.sub}}.{{.Claims.level}}")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ep := Endpoint{ParamEscaping: tc.escaping}
+			rules, _ := newParamRules(ep)
+			topic, err := getTopic(tmpl, pxy.topicData(httptest.NewRequest("GET", "/", nil), nil, ep, rules))
+			if err != nil || topic != tc.result {
+				t.Errorf("Unexpected topic: got %v %v want %v", topic, err, tc.result)
+			}
+		})
+	}
+}
+
+func TestGetTopicHandlerInvalidTopic(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	pxy, _ := New(":80", service)
+	r := &MockLogger{}
+	pxy.Requests = r
+	pxy.Debugger = &MockLogger{}
+
+	h, err := pxy.getTopicHandler(Endpoint{Path: "/w/:id", Method: "GET", Topic: "service.w.{{.id}}", ParamEscaping: RawParams})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest("GET", "/w/*", nil), httprouter.Params{{Key: "id", Value: "*"}})
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status: %v", rr.Code)
+	}
+	if len(r.storage) != 1 || r.storage[0] != "GET:/w/*, status: 400, topic: service.w.*" {
+		t.Errorf("Unexpected request log: %v", r.storage)
+	}
+}
+
+func TestGetTopicHandlerEscapedParams(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	pxy, _ := New(":80", service)
+	pxy.Logger = &MockLogger{}
+	r := &MockLogger{}
+	pxy.Requests = r
+	pxy.Debugger = &MockLogger{}
+
+	h, err := pxy.getTopicHandler(Endpoint{
+		Path:      "/w/:id",
+		Method:    "GET",
+		Topic:     "service.w.{{.id}}",
+		KeepAlive: 1,
+		Params:    map[string]ParamConstraint{"id": {Type: "int"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		id     string
+		status int
+		log    string
+	}{
+		{"a", http.StatusBadRequest, "GET:/w/a, status: 400"},
+		{"1.2", http.StatusBadRequest, "GET:/w/1.2, status: 400"},
+		{"1", http.StatusRequestTimeout, "GET:/w/1, status: 408, topic: service.w.1, Id: "},
+	}
+
+	for i, tc := range cases {
+		rr := httptest.NewRecorder()
+		h(rr, httptest.NewRequest("GET", "/w/"+tc.id, nil), httprouter.Params{{Key: "id", Value: tc.id}})
+
+		if rr.Code != tc.status {
+			t.Errorf("Case %v: unexpected status: %v", i, rr.Code)
+		}
+		if r.storage[len(r.storage)-1] != tc.log {
+			t.Errorf("Case %v: unexpected request log: %v", i, r.storage)
+		}
+	}
+}
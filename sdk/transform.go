@@ -0,0 +1,210 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/miracl/mrpcproxy"
+)
+
+// TransformData is the data available to the endpoint request and response templates.
+//
+// Body is the decoded JSON body or the raw body as string if it's not JSON.
+type TransformData struct {
+	Method  string
+	Params  map[string]string
+	Query   url.Values
+	Headers http.Header
+	Claims  map[string]interface{}
+	Body    interface{}
+
+	// Response only
+	Code int
+}
+
+var transformFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"jsonEscape": jsonEscape,
+}
+
+// jsonEscape escapes the value for a JSON string. The values that are not strings are
+// encoded as JSON first, so a value printed outside of a string can't add JSON keys either.
+func jsonEscape(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		s = string(b)
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return string(b[1 : len(b)-1]), nil
+}
+
+// bodyTransform reshapes the request and response bodies of an endpoint.
+type bodyTransform struct {
+	req *template.Template
+	res *template.Template
+}
+
+func newBodyTransform(ep Endpoint) (*bodyTransform, error) {
+	t := &bodyTransform{}
+	var err error
+	if ep.RequestTemplate != "" {
+		t.req, err = parseTransform("request", ep.RequestTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ep.ResponseTemplate != "" {
+		t.res, err = parseTransform("response", ep.ResponseTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// parseTransform parses the body template and escapes every value it prints that isn't
+// encoded with json, so e.g. "{{.Params.id}}" can't inject JSON.
+func parseTransform(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(transformFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil {
+			escapeTransformNode(tmpl.Tree, tmpl.Tree.Root)
+		}
+	}
+	return t, nil
+}
+
+func escapeTransformNode(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			escapeTransformNode(tree, child)
+		}
+	case *parse.ActionNode:
+		escapeTransformPipe(tree, n.Pipe)
+	case *parse.IfNode:
+		escapeTransformNode(tree, n.List)
+		escapeTransformNode(tree, n.ElseList)
+	case *parse.RangeNode:
+		escapeTransformNode(tree, n.List)
+		escapeTransformNode(tree, n.ElseList)
+	case *parse.WithNode:
+		escapeTransformNode(tree, n.List)
+		escapeTransformNode(tree, n.ElseList)
+	}
+}
+
+// escapeTransformPipe appends jsonEscape to the printed pipeline unless it ends with json.
+func escapeTransformPipe(tree *parse.Tree, pipe *parse.PipeNode) {
+	if pipe == nil || len(pipe.Decl) > 0 || len(pipe.Cmds) == 0 {
+		// Variable declarations don't print
+		return
+	}
+
+	last := pipe.Cmds[len(pipe.Cmds)-1]
+	if id, ok := last.Args[0].(*parse.IdentifierNode); ok && (id.Ident == "json" || id.Ident == "jsonEscape") {
+		return
+	}
+
+	escape := parse.NewIdentifier("jsonEscape").SetTree(tree).SetPos(pipe.Pos)
+	pipe.Cmds = append(pipe.Cmds, &parse.CommandNode{NodeType: parse.NodeCommand, Pos: pipe.Pos, Args: []parse.Node{escape}})
+}
+
+// request replaces the HTTP request body with the rendered request template.
+func (t *bodyTransform) request(pxy *Proxy, r *http.Request, p httprouter.Params) error {
+	if t.req == nil {
+		return nil
+	}
+
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+	}
+
+	data := pxy.newTransformData(r, p, body)
+	msg, err := renderTransform(t.req, data)
+	if err != nil {
+		return err
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(msg))
+	r.ContentLength = int64(len(msg))
+	return nil
+}
+
+// response replaces the message of the successful service response with the rendered
+// response template. The error responses, e.g. the proxy 408, are returned as they are.
+func (t *bodyTransform) response(pxy *Proxy, r *http.Request, p httprouter.Params, res *mrpcproxy.Response) error {
+	if t.res == nil || res.Code >= http.StatusBadRequest {
+		return nil
+	}
+
+	data := pxy.newTransformData(r, p, res.Msg)
+	data.Code = res.Code
+	msg, err := renderTransform(t.res, data)
+	if err != nil {
+		return err
+	}
+
+	res.Msg = msg
+	return nil
+}
+
+func (pxy *Proxy) newTransformData(r *http.Request, p httprouter.Params, body []byte) *TransformData {
+	data := &TransformData{
+		Method:  r.Method,
+		Params:  map[string]string{},
+		Query:   r.URL.Query(),
+		Headers: r.Header,
+	}
+	for _, param := range p {
+		data.Params[param.Key] = param.Value
+	}
+	if pxy.Claims != nil {
+		data.Claims = pxy.Claims(r)
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &data.Body); err != nil {
+			data.Body = string(body)
+		}
+	}
+
+	return data
+}
+
+func renderTransform(t *template.Template, data *TransformData) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
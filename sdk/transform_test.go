@@ -0,0 +1,145 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+func TestBodyTransform(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	// Echo the request message
+	service.HandleFunc("echo", func(w mrpc.TopicWriter, data []byte) {
+		req := &mrpcproxy.Request{}
+		json.Unmarshal(data, req)
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 200, Msg: req.Msg})
+		w.Write(msg)
+	})
+	service.HandleFunc("missing", func(w mrpc.TopicWriter, data []byte) {
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 404, Msg: []byte("not found")})
+		w.Write(msg)
+	})
+
+	cases := []struct {
+		topic   string
+		id      string
+		reqTmpl string
+		resTmpl string
+		reqBody string
+		status  int
+		resBody string
+	}{
+		{
+			reqBody: `{"name":"a"}`,
+			resBody: `{"name":"a"}`,
+		},
+		{
+			reqTmpl: `{"user_id":{{json .Params.id}},"full_name":{{json .Body.name}},"sub":{{json .Claims.sub}}}`,
+			reqBody: `{"name":"a"}`,
+			resBody: `{"user_id":"1","full_name":"a","sub":"claim"}`,
+		},
+		{
+			id:      `a","admin":true`,
+			reqTmpl: `{"user_id":{{json .Params.id}}}`,
+			resBody: `{"user_id":"a\",\"admin\":true"}`,
+		},
+		{
+			id:      `a","admin":true`,
+			reqTmpl: `{"user_id":"{{.Params.id}}"}`,
+			resBody: `{"user_id":"a\",\"admin\":true"}`,
+		},
+		{
+			id:      `1,"admin":true`,
+			reqTmpl: `{"user_id":{{.Params.id}}}`,
+			resBody: `{"user_id":1,\"admin\":true}`,
+		},
+		{
+			reqTmpl: `{{$name := .Body.name}}{"name":"{{$name}}","size":{{len .Body.name}},"body":{{.Body | json}}}`,
+			reqBody: `{"name":"a\"b"}`,
+			resBody: `{"name":"a\"b","size":3,"body":{"name":"a\"b"}}`,
+		},
+		{
+			topic:   "service.missing",
+			resTmpl: `{"data":{{json .Body}}}`,
+			status:  404,
+			resBody: `not found`,
+		},
+		{
+			resTmpl: `{"code":{{.Code}},"data":{{json .Body}},"q":"{{.Query.Get "q"}}"}`,
+			reqBody: `{"name":"a"}`,
+			resBody: `{"code":200,"data":{"name":"a"},"q":"b"}`,
+		},
+		{
+			resTmpl: `{{.Headers.Get "X-Test"}}:{{.Body}}`,
+			reqBody: `text`,
+			resBody: `test:text`,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			pxy, _ := New(":80", service)
+			pxy.Logger = &MockLogger{}
+			pxy.Requests = &MockLogger{}
+			pxy.Claims = func(r *http.Request) map[string]interface{} {
+				return map[string]interface{}{"sub": "claim"}
+			}
+
+			if tc.topic == "" {
+				tc.topic = "service.echo"
+			}
+			if tc.id == "" {
+				tc.id = "1"
+			}
+			if tc.status == 0 {
+				tc.status = http.StatusOK
+			}
+
+			h, err := pxy.getTopicHandler(Endpoint{
+				Path:             "/echo/:id",
+				Method:           "POST",
+				Topic:            tc.topic,
+				RequestTemplate:  tc.reqTmpl,
+				ResponseTemplate: tc.resTmpl,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, _ := http.NewRequest("POST", "/echo/1?q=b", bytes.NewBufferString(tc.reqBody))
+			req.Header.Set("X-Test", "test")
+			rr := httptest.NewRecorder()
+			h(rr, req, httprouter.Params{{Key: "id", Value: tc.id}})
+
+			if rr.Code != tc.status {
+				t.Errorf("Unexpected status: %v", rr.Code)
+			}
+			if rr.Body.String() != tc.resBody {
+				t.Errorf("Unexpected body\nExpected: %v\nReceived: %v", tc.resBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestBodyTransformParseError(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	pxy, _ := New(":80", service)
+
+	_, err := pxy.getTopicHandler(Endpoint{
+		Path:            "/a",
+		Method:          "POST",
+		Topic:           "service.a",
+		RequestTemplate: "{{.Body",
+	})
+	if err == nil {
+		t.Error("Expected template parse error")
+	}
+}
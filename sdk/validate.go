@@ -0,0 +1,395 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Locations of the violations.
+const (
+	InBody  = "body"
+	InQuery = "query"
+)
+
+// Schema is the JSON Schema subset, also valid as OpenAPI schema, validating the request
+// body and the query parameters. The other keywords are ignored.
+//
+// Type is object, array, string, integer, number or boolean. Format is email, uuid,
+// date or date-time.
+type Schema struct {
+	Type     string        `json:"type,omitempty"`
+	Nullable bool          `json:"nullable,omitempty"`
+	Enum     []interface{} `json:"enum,omitempty"`
+
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+
+	Items    *Schema `json:"items,omitempty"`
+	MinItems *int    `json:"minItems,omitempty"`
+	MaxItems *int    `json:"maxItems,omitempty"`
+
+	MinLength *int   `json:"minLength,omitempty"`
+	MaxLength *int   `json:"maxLength,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+	Format    string `json:"format,omitempty"`
+
+	Minimum *float64 `json:"minimum,omitempty"`
+	Maximum *float64 `json:"maximum,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Violation is a single reason the request is invalid. Field is the JSON pointer of the
+// body value or the name of the query parameter.
+type Violation struct {
+	In      string `json:"in"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Problem is the RFC 7807 problem details response of the invalid requests.
+type Problem struct {
+	Type       string      `json:"type"`
+	Title      string      `json:"title"`
+	Status     int         `json:"status"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// compile checks the schema and compiles the patterns.
+func (s *Schema) compile() error {
+	switch s.Type {
+	case "", "object", "array", "string", "integer", "number", "boolean":
+	default:
+		return fmt.Errorf("unknown schema type: %v", s.Type)
+	}
+	switch s.Format {
+	case "", "email", "uuid", "date", "date-time":
+	default:
+		return fmt.Errorf("unknown schema format: %v", s.Format)
+	}
+
+	if s.Pattern != "" {
+		var err error
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return err
+		}
+	}
+	for _, p := range s.Properties {
+		if err := p.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+// validate returns the violations of the value at the JSON pointer.
+func (s *Schema) validate(in, pointer string, v interface{}) []Violation {
+	violation := func(format string, args ...interface{}) []Violation {
+		return []Violation{{In: in, Field: pointer, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	if v == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return violation("must not be null")
+	}
+	if !s.hasType(v) {
+		return violation("must be %v", s.Type)
+	}
+	if len(s.Enum) > 0 && !s.inEnum(v) {
+		return violation("must be one of %v", s.Enum)
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return s.validateObject(in, pointer, v)
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return violation("must have at least %v items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return violation("must have at most %v items", *s.MaxItems)
+		}
+		if s.Items == nil {
+			return nil
+		}
+		violations := []Violation{}
+		for i, item := range v {
+			violations = append(violations, s.Items.validate(in, pointer+"/"+strconv.Itoa(i), item)...)
+		}
+		return violations
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			return violation("must be at least %v characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return violation("must be at most %v characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return violation("must match %v", s.Pattern)
+		}
+		if !validFormat(s.Format, v) {
+			return violation("must be %v", s.Format)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return violation("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return violation("must be at most %v", *s.Maximum)
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateObject(in, pointer string, v map[string]interface{}) []Violation {
+	violations := []Violation{}
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			violations = append(violations, Violation{In: in, Field: pointer + "/" + escapePointer(name), Message: "is required"})
+		}
+	}
+
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := pointer + "/" + escapePointer(name)
+		p, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				violations = append(violations, Violation{In: in, Field: field, Message: "is not allowed"})
+			}
+			continue
+		}
+		violations = append(violations, p.validate(in, field, v[name])...)
+	}
+
+	return violations
+}
+
+func (s *Schema) hasType(v interface{}) bool {
+	switch s.Type {
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "integer":
+		n, ok := v.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	}
+	return true
+}
+
+// inEnum compares the decoded JSON values, the object and array values aren't comparable
+// with ==.
+func (s *Schema) inEnum(v interface{}) bool {
+	for _, e := range s.Enum {
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func validFormat(format, v string) bool {
+	switch format {
+	case "email":
+		addr, err := mail.ParseAddress(v)
+		return err == nil && addr.Address == v
+	case "uuid":
+		return uuidRegexp.MatchString(v)
+	case "date":
+		_, err := time.Parse("2006-01-02", v)
+		return err == nil
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, v)
+		return err == nil
+	}
+	return true
+}
+
+// escapePointer escapes the JSON pointer reference token.
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+// queryValue converts the query parameter values to the schema type. The values that
+// can't be converted are kept as strings and fail the validation.
+func queryValue(s *Schema, values []string) interface{} {
+	if s.Type == "array" {
+		items := make([]interface{}, len(values))
+		for i, v := range values {
+			items[i] = v
+			if s.Items != nil {
+				items[i] = queryValue(s.Items, []string{v})
+			}
+		}
+		return items
+	}
+
+	v := values[0]
+	switch s.Type {
+	case "integer", "number":
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return n
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return v
+}
+
+// requestValidator validates the request body and query with the endpoint schemas.
+type requestValidator struct {
+	body, query *Schema
+}
+
+func newRequestValidator(ep Endpoint) (*requestValidator, error) {
+	if ep.BodySchema == nil && ep.QuerySchema == nil {
+		return nil, nil
+	}
+
+	for _, s := range []*Schema{ep.BodySchema, ep.QuerySchema} {
+		if s == nil {
+			continue
+		}
+		if err := s.compile(); err != nil {
+			return nil, fmt.Errorf("invalid schema of %v %v: %v", ep.Method, ep.Path, err)
+		}
+	}
+	if ep.QuerySchema != nil && ep.QuerySchema.Type != "" && ep.QuerySchema.Type != "object" {
+		return nil, fmt.Errorf("invalid schema of %v %v: the query schema must be object", ep.Method, ep.Path)
+	}
+
+	return &requestValidator{ep.BodySchema, ep.QuerySchema}, nil
+}
+
+// check returns the violations of the request. The body is read and restored.
+func (v *requestValidator) check(r *http.Request) ([]Violation, error) {
+	violations := []Violation{}
+	if v.query != nil {
+		violations = append(violations, v.checkQuery(r.URL.Query())...)
+	}
+	if v.body == nil {
+		return violations, nil
+	}
+
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return nil, err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return append(violations, Violation{In: InBody, Message: "is required"}), nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return append(violations, Violation{In: InBody, Message: fmt.Sprintf("invalid JSON: %v", err)}), nil
+	}
+	return append(violations, v.body.validate(InBody, "", value)...), nil
+}
+
+func (v *requestValidator) checkQuery(query url.Values) []Violation {
+	violations := []Violation{}
+	for _, name := range v.query.Required {
+		if _, ok := query[name]; !ok {
+			violations = append(violations, Violation{In: InQuery, Field: name, Message: "is required"})
+		}
+	}
+
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p, ok := v.query.Properties[name]
+		if !ok {
+			if v.query.AdditionalProperties != nil && !*v.query.AdditionalProperties {
+				violations = append(violations, Violation{In: InQuery, Field: name, Message: "is not allowed"})
+			}
+			continue
+		}
+		for _, violation := range p.validate(InQuery, "", queryValue(p, query[name])) {
+			violation.Field = name + violation.Field
+			violations = append(violations, violation)
+		}
+	}
+
+	return violations
+}
+
+// validateRequest responds with 400 and the problem details when the request is invalid.
+// The requests of the endpoints without the schemas are valid.
+func (pxy *Proxy) validateRequest(w http.ResponseWriter, r *http.Request, v *requestValidator, topic string) bool {
+	if v == nil {
+		return true
+	}
+
+	violations, err := v.check(r)
+	if err != nil {
+		pxy.Debugger.Println(err)
+		pxy.Requests.Printf("%v:%v, status: %v, topic: %v", r.Method, r.URL.Path, http.StatusBadRequest, topic)
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	if len(violations) == 0 {
+		return true
+	}
+
+	body, _ := json.Marshal(&Problem{
+		Type:       "about:blank",
+		Title:      "The request is invalid",
+		Status:     http.StatusBadRequest,
+		Violations: violations,
+	})
+
+	pxy.setHeaders(w)
+	w.Header().Set("Content-Type", "application/problem+json")
+	pxy.Requests.Printf("%v:%v, status: %v, topic: %v", r.Method, r.URL.Path, http.StatusBadRequest, topic)
+	w.WriteHeader(http.StatusBadRequest)
+	if _, err := w.Write(body); err != nil {
+		pxy.Debugger.Println(err)
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miracl/mrpc"
+	"github.com/miracl/mrpc/transport/mem"
+	"github.com/miracl/mrpcproxy"
+)
+
+const testUserSchema = `{
+	"type": "object",
+	"required": ["name", "email"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 2, "maxLength": 10},
+		"email": {"type": "string", "format": "email"},
+		"age": {"type": "integer", "minimum": 18},
+		"role": {"type": "string", "enum": ["admin", "user"]},
+		"origin": {"enum": [{"x": 0, "y": 0}, [0, 0], "center"]},
+		"id": {"type": "string", "format": "uuid", "nullable": true},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string", "pattern": "^[a-z]+$"}}
+	}
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	schema := &Schema{}
+	if err := json.Unmarshal([]byte(testUserSchema), schema); err != nil {
+		t.Fatal(err)
+	}
+	if err := schema.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		body       string
+		violations []Violation
+	}{
+		{body: `{"name": "Al", "email": "al@example.com"}`},
+		{body: `{"name": "Al", "email": "al@example.com", "age": 20, "role": "admin", "id": null, "tags": ["a", "b"]}`},
+		{body: `[]`, violations: []Violation{{InBody, "", "must be object"}}},
+		{
+			body: `{}`,
+			violations: []Violation{
+				{InBody, "/name", "is required"},
+				{InBody, "/email", "is required"},
+			},
+		},
+		{
+			body: `{"name": "A", "email": "not an email", "a/b": 1}`,
+			violations: []Violation{
+				{InBody, "/a~1b", "is not allowed"},
+				{InBody, "/email", "must be email"},
+				{InBody, "/name", "must be at least 2 characters"},
+			},
+		},
+		{body: `{"name": "Alexander the Great", "email": "a@b.c"}`, violations: []Violation{{InBody, "/name", "must be at most 10 characters"}}},
+		{body: `{"name": "Al", "email": "a@b.c", "age": 17}`, violations: []Violation{{InBody, "/age", "must be at least 18"}}},
+		{body: `{"name": "Al", "email": "a@b.c", "age": 18.5}`, violations: []Violation{{InBody, "/age", "must be integer"}}},
+		{body: `{"name": "Al", "email": "a@b.c", "role": "root"}`, violations: []Violation{{InBody, "/role", "must be one of [admin user]"}}},
+		{body: `{"name": "Al", "email": "a@b.c", "origin": {"y": 0, "x": 0}}`},
+		{body: `{"name": "Al", "email": "a@b.c", "origin": [0, 0]}`},
+		{
+			body:       `{"name": "Al", "email": "a@b.c", "origin": [0, 1]}`,
+			violations: []Violation{{InBody, "/origin", "must be one of [map[x:0 y:0] [0 0] center]"}},
+		},
+		{body: `{"name": "Al", "email": "a@b.c", "id": "1"}`, violations: []Violation{{InBody, "/id", "must be uuid"}}},
+		{body: `{"name": null, "email": "a@b.c"}`, violations: []Violation{{InBody, "/name", "must not be null"}}},
+		{body: `{"name": "Al", "email": "a@b.c", "tags": ["a", "b", "c"]}`, violations: []Violation{{InBody, "/tags", "must have at most 2 items"}}},
+		{body: `{"name": "Al", "email": "a@b.c", "tags": ["a", "B"]}`, violations: []Violation{{InBody, "/tags/1", "must match ^[a-z]+$"}}},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			var v interface{}
+			if err := json.Unmarshal([]byte(tc.body), &v); err != nil {
+				t.Fatal(err)
+			}
+			violations := schema.validate(InBody, "", v)
+			if len(violations) == 0 && len(tc.violations) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(violations, tc.violations) {
+				t.Errorf("Unexpected violations\nExpected: %v\nReceived: %v", tc.violations, violations)
+			}
+		})
+	}
+}
+
+func TestRequestValidation(t *testing.T) {
+	service, _ := mrpc.NewService(mem.New())
+	published := 0
+	service.HandleFunc("users", func(w mrpc.TopicWriter, data []byte) {
+		published++
+		msg, _ := json.Marshal(&mrpcproxy.Response{Code: 201})
+		w.Write(msg)
+	})
+
+	body := &Schema{}
+	json.Unmarshal([]byte(testUserSchema), body)
+	query := &Schema{}
+	json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["tenant"],
+		"properties": {
+			"tenant": {"type": "string"},
+			"dryRun": {"type": "boolean"},
+			"ids": {"type": "array", "items": {"type": "integer"}}
+		}
+	}`), query)
+
+	pxy, _ := New(":80", service)
+	pxy.Logger = &MockLogger{}
+	pxy.Requests = &MockLogger{}
+	pxy.Debugger = &MockLogger{}
+	err := pxy.Handle(Endpoint{Path: "/users", Method: "POST", Topic: "service.users", BodySchema: body, QuerySchema: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pxy.setupRouter()
+
+	cases := []struct {
+		query      string
+		body       string
+		status     int
+		violations []Violation
+	}{
+		{query: "?tenant=a", body: `{"name": "Al", "email": "al@example.com"}`, status: 201},
+		{query: "?tenant=a&dryRun=true&ids=1&ids=2", body: `{"name": "Al", "email": "al@example.com"}`, status: 201},
+		{query: "?tenant=a", status: 400, violations: []Violation{{InBody, "", "is required"}}},
+		{
+			query:      "?tenant=a",
+			body:       `{"name": "Al",`,
+			status:     400,
+			violations: []Violation{{InBody, "", "invalid JSON: unexpected end of JSON input"}},
+		},
+		{
+			query:  "?dryRun=maybe&ids=1&ids=a",
+			body:   `{"name": "Al"}`,
+			status: 400,
+			violations: []Violation{
+				{InQuery, "tenant", "is required"},
+				{InQuery, "dryRun", "must be boolean"},
+				{InQuery, "ids/1", "must be integer"},
+				{InBody, "/email", "is required"},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			published = 0
+			rr := httptest.NewRecorder()
+			pxy.ServeHTTP(rr, httptest.NewRequest("POST", "/users"+tc.query, strings.NewReader(tc.body)))
+
+			if rr.Code != tc.status {
+				t.Fatalf("Unexpected status: got %v want %v, %v", rr.Code, tc.status, rr.Body.String())
+			}
+			if tc.status != http.StatusBadRequest {
+				if published != 1 {
+					t.Errorf("Request not published")
+				}
+				return
+			}
+
+			if published != 0 {
+				t.Errorf("Invalid request published")
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("Unexpected content type: %v", ct)
+			}
+			problem := &Problem{}
+			if err := json.Unmarshal(rr.Body.Bytes(), problem); err != nil {
+				t.Fatal(err)
+			}
+			if problem.Status != 400 || !reflect.DeepEqual(problem.Violations, tc.violations) {
+				t.Errorf("Unexpected problem\nExpected: %v\nReceived: %v", tc.violations, problem.Violations)
+			}
+		})
+	}
+}
+
+func TestRequestValidatorErrors(t *testing.T) {
+	cases := []struct {
+		ep  Endpoint
+		err string
+	}{
+		{ep: Endpoint{BodySchema: &Schema{Type: "map"}}, err: "unknown schema type: map"},
+		{ep: Endpoint{BodySchema: &Schema{Items: &Schema{Format: "ipv4"}}}, err: "unknown schema format: ipv4"},
+		{ep: Endpoint{BodySchema: &Schema{Properties: map[string]*Schema{"a": {Pattern: "("}}}}, err: "missing closing )"},
+		{ep: Endpoint{QuerySchema: &Schema{Type: "array"}}, err: "the query schema must be object"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("Case%v", i), func(t *testing.T) {
+			_, err := newRequestValidator(tc.ep)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Unexpected error: got %v want %v", err, tc.err)
+			}
+		})
+	}
+}
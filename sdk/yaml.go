@@ -0,0 +1,120 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlPosition is the position in the YAML file of the JSON value at offset.
+type yamlPosition struct {
+	offset       int64
+	line, column int
+}
+
+// yamlConverter writes the YAML nodes as JSON keeping the positions of the values, so the
+// JSON decoding errors are reported at the YAML line and column.
+type yamlConverter struct {
+	buf       bytes.Buffer
+	positions []yamlPosition
+}
+
+// yamlToJSON converts the YAML document to JSON.
+func yamlToJSON(data []byte) ([]byte, []yamlPosition, error) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, nil, err
+	}
+
+	c := &yamlConverter{}
+	if len(doc.Content) == 0 {
+		// Empty file
+		c.buf.WriteString("{}")
+		return c.buf.Bytes(), nil, nil
+	}
+	if err := c.write(doc.Content[0]); err != nil {
+		return nil, nil, err
+	}
+	return c.buf.Bytes(), c.positions, nil
+}
+
+func (c *yamlConverter) write(n *yaml.Node) error {
+	c.positions = append(c.positions, yamlPosition{offset: int64(c.buf.Len()), line: n.Line, column: n.Column})
+
+	switch n.Kind {
+	case yaml.AliasNode:
+		return c.write(n.Alias)
+	case yaml.MappingNode:
+		c.buf.WriteByte('{')
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			if key.Kind != yaml.ScalarNode {
+				return fmt.Errorf("yaml: line %v: mapping key is not a string", key.Line)
+			}
+			if i > 0 {
+				c.buf.WriteByte(',')
+			}
+			c.positions = append(c.positions, yamlPosition{offset: int64(c.buf.Len()), line: key.Line, column: key.Column})
+			if err := c.scalar(key.Value); err != nil {
+				return err
+			}
+			c.buf.WriteByte(':')
+			if err := c.write(n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		c.buf.WriteByte('}')
+	case yaml.SequenceNode:
+		c.buf.WriteByte('[')
+		for i, item := range n.Content {
+			if i > 0 {
+				c.buf.WriteByte(',')
+			}
+			if err := c.write(item); err != nil {
+				return err
+			}
+		}
+		c.buf.WriteByte(']')
+	default:
+		switch n.ShortTag() {
+		case "!!null":
+			c.buf.WriteString("null")
+		case "!!bool", "!!int", "!!float":
+			var v interface{}
+			if err := n.Decode(&v); err != nil {
+				return err
+			}
+			if err := c.scalar(v); err != nil {
+				return fmt.Errorf("yaml: line %v: %v", n.Line, err)
+			}
+		default:
+			// Strings, timestamps and binary values are kept as written
+			return c.scalar(n.Value)
+		}
+	}
+	return nil
+}
+
+func (c *yamlConverter) scalar(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.buf.Write(data)
+	return nil
+}
+
+// newYAMLConfigError returns the error at the YAML position of the JSON offset.
+func newYAMLConfigError(path string, positions []yamlPosition, offset int64, err error) ConfigError {
+	// The last value starting before the offset
+	i := sort.Search(len(positions), func(i int) bool { return positions[i].offset > offset })
+	if offset < 0 || i == 0 {
+		return ConfigError{File: path, err: err}
+	}
+
+	pos := positions[i-1]
+	return ConfigError{File: path, Line: pos.line, Column: pos.column, err: err}
+}